@@ -8,9 +8,19 @@
 package main
 
 import (
+	"github.com/bitquark/shortscan/pkg/shortscan"
 	"github.com/bitquark/shortscan/pkg/shortutil"
+	log "github.com/sirupsen/logrus"
 )
 
 func main() {
+
+	// Autocomplete with shortscan's wordlist matching (quietly, since it logs as it goes)
+	shortutil.LoadCompleter = func(wordlist string) (shortutil.Completer, error) {
+		log.SetLevel(log.WarnLevel)
+		return shortscan.LoadWordlist(wordlist)
+	}
+
 	shortutil.Run()
+
 }
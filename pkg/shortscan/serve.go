@@ -0,0 +1,355 @@
+// ------------------------------------------------------
+// Shortscan HTTP API
+// Scans are started with POST /scans and followed with GET /scans/{id}/results
+// ------------------------------------------------------
+
+package shortscan
+
+import (
+	"io"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+	"context"
+	"strings"
+	"net/http"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+)
+
+type scanJob struct {
+	sync.Mutex
+	ID       string     `json:"id"`
+	Url      string     `json:"url"`
+	State    string     `json:"state"`
+	Error    string     `json:"error,omitempty"`
+	Results  int        `json:"results"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+	records  []any
+	changed  chan struct{}
+	cancel   context.CancelFunc
+	removed  bool
+}
+
+type jobServer struct {
+	sync.Mutex
+	s    *Scanner
+	jobs map[string]*scanJob
+	sem  chan struct{}
+}
+
+type scanRequest struct {
+	Url string `json:"url"`
+}
+
+// serve runs the HTTP API on the given address until it fails
+func (s *Scanner) serve(addr string) error {
+
+	// Listen on localhost unless a host was given
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		host = "127.0.0.1"
+		addr = net.JoinHostPort(host, port)
+	}
+
+	// Anyone who can reach the API can make the server scan whatever they like, so warn loudly if that's not just this machine
+	if ip := net.ParseIP(host); s.opts.ServeToken == "" && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		log.WithFields(log.Fields{"addr": addr}).Warn("The API is reachable from other machines without authentication, so anyone who can connect can use it to scan any URL (set --serve-token to require a token)")
+	}
+
+	// Time out slow clients (there's no write timeout as results are streamed for as long as a scan runs)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           newJobServer(s),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	s.printHuman(fmt.Sprintf("Listening on %s (POST /scans to start a scan)", addr))
+	log.WithFields(log.Fields{"addr": addr, "jobs": s.opts.ServeJobs}).Info("API server listening")
+	return srv.ListenAndServe()
+
+}
+
+// newJobServer returns a handler for the HTTP API, which runs scans with copies of the given scanner
+func newJobServer(s *Scanner) *jobServer {
+	return &jobServer{s: s, jobs: make(map[string]*scanJob), sem: make(chan struct{}, s.opts.ServeJobs)}
+}
+
+// ServeHTTP routes API requests: POST /scans, GET or DELETE /scans/{id} and GET /scans/{id}/results
+func (js *jobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	// Check the bearer token if one is required
+	if t := js.s.opts.ServeToken; t != "" {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+t)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			apiError(w, http.StatusUnauthorized, "a valid bearer token is required")
+			return
+		}
+	}
+
+	// Forget scans which finished a while ago
+	js.prune()
+
+	// Split the path into the job ID and what's wanted
+	p := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if p[0] != "scans" || len(p) > 3 {
+		apiError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	// Start a scan
+	if len(p) == 1 {
+		if r.Method != http.MethodPost {
+			apiError(w, http.StatusMethodNotAllowed, "use POST to start a scan")
+			return
+		}
+		js.start(w, r)
+		return
+	}
+
+	// Find the job
+	js.Lock()
+	j, ok := js.jobs[p[1]]
+	js.Unlock()
+	if !ok {
+		apiError(w, http.StatusNotFound, "no such scan")
+		return
+	}
+
+	switch {
+
+	// Job status
+	case len(p) == 2 && r.Method == http.MethodGet:
+		j.Lock()
+		b, _ := json.Marshal(j)
+		j.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(append(b, '\n'))
+
+	// Cancel the job and forget it (straight away if it's already finished, otherwise once it stops)
+	case len(p) == 2 && r.Method == http.MethodDelete:
+		j.cancel()
+		j.Lock()
+		done := j.Finished != nil
+		j.removed = !done
+		j.Unlock()
+		if done {
+			js.forget(j.ID)
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	// Stream the records
+	case len(p) == 3 && p[2] == "results" && r.Method == http.MethodGet:
+		js.stream(w, r, j)
+
+	default:
+		apiError(w, http.StatusNotFound, "not found")
+
+	}
+
+}
+
+// start queues a scan of the URL in the request body and returns the new job
+func (js *jobServer) start(w http.ResponseWriter, r *http.Request) {
+
+	// Read and check the URL
+	var sr scanRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&sr); err != nil {
+		apiError(w, http.StatusBadRequest, "request body must be JSON with a url")
+		return
+	}
+	u, err := baseUrl(sr.Url)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, fmt.Sprintf("invalid url: %s", err))
+		return
+	}
+
+	// Create the job
+	id := make([]byte, 8)
+	rand.Read(id)
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &scanJob{ID: hex.EncodeToString(id), Url: u, State: "queued", Created: time.Now(), changed: make(chan struct{}), cancel: cancel}
+	js.Lock()
+	js.jobs[j.ID] = j
+	js.Unlock()
+	log.WithFields(log.Fields{"id": j.ID, "url": u}).Info("Scan queued")
+
+	// Run it in the background
+	go js.run(ctx, j)
+
+	// Return the job
+	j.Lock()
+	b, _ := json.Marshal(j)
+	j.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/scans/"+j.ID)
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(append(b, '\n'))
+
+}
+
+// run waits for a free slot then scans the job's URL, recording everything found as it goes
+func (js *jobServer) run(ctx context.Context, j *scanJob) {
+
+	// Release the job's context once it's done, and forget it if it was deleted while running
+	defer func() {
+		j.cancel()
+		j.Lock()
+		removed := j.removed
+		j.Unlock()
+		if removed {
+			js.forget(j.ID)
+		}
+	}()
+
+	// Wait for a slot (unless the job is cancelled first)
+	select {
+	case js.sem <- struct{}{}:
+		defer func() { <-js.sem }()
+	case <-ctx.Done():
+		j.finish("cancelled", nil)
+		return
+	}
+	j.Lock()
+	j.State = "running"
+	j.Unlock()
+	j.add(eventOutput{Type: "event", Version: version, Event: "start", Urls: []string{j.Url}})
+
+	// Scan with a copy of the scanner which sends results to the job rather than printing them
+	c := *js.s
+	c.opts.Output, c.out = "none", io.Discard
+	c.onResult = func(r Result) { j.add(r) }
+	c.onStatus = func(st Status) { j.add(st) }
+	_, st, err := c.scan(ctx, j.Url)
+
+	// Finish up with the request stats
+	j.add(statsOutput{Type: "statistics", Version: version, Requests: st.requests, Retries: st.retries, Failures: st.failures, Timeouts: st.timeouts, SentBytes: st.bytesTx, ReceivedBytes: st.bytesRx})
+	j.add(eventOutput{Type: "event", Version: version, Event: "complete", Url: j.Url})
+	switch {
+	case ctx.Err() != nil:
+		j.finish("cancelled", nil)
+	case err != nil:
+		j.finish("failed", err)
+	default:
+		j.finish("finished", nil)
+	}
+
+}
+
+// prune forgets jobs (and their records) which finished longer ago than the scanner's --serve-ttl, so a long-running
+// server doesn't hold on to every scan it has ever run
+func (js *jobServer) prune() {
+
+	js.Lock()
+	defer js.Unlock()
+	for id, j := range js.jobs {
+		j.Lock()
+		expired := j.Finished != nil && time.Since(*j.Finished) > js.s.opts.ServeTTL
+		j.Unlock()
+		if expired {
+			delete(js.jobs, id)
+		}
+	}
+
+}
+
+// forget removes a job
+func (js *jobServer) forget(id string) {
+	js.Lock()
+	delete(js.jobs, id)
+	js.Unlock()
+	log.WithFields(log.Fields{"id": id}).Info("Scan removed")
+}
+
+// add appends a record to the job and wakes up anything streaming it
+func (j *scanJob) add(o any) {
+	j.Lock()
+	defer j.Unlock()
+	j.records = append(j.records, o)
+	if _, ok := o.(Result); ok {
+		j.Results++
+	}
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// finish marks the job as done
+func (j *scanJob) finish(state string, err error) {
+	j.Lock()
+	defer j.Unlock()
+	t := time.Now()
+	j.State, j.Finished = state, &t
+	if err != nil {
+		j.Error = err.Error()
+	}
+	close(j.changed)
+	j.changed = make(chan struct{})
+	log.WithFields(log.Fields{"id": j.ID, "url": j.Url, "state": state, "results": j.Results}).Info("Scan done")
+}
+
+// stream writes the job's records as they arrive until it's done (or the client goes away), as server-sent
+// events if the client asks for them or newline-delimited JSON otherwise
+func (js *jobServer) stream(w http.ResponseWriter, r *http.Request, j *scanJob) {
+
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	fl, _ := w.(http.Flusher)
+
+	// Send new records, then wait for more
+	n := 0
+	for {
+
+		// Grab anything not yet sent
+		j.Lock()
+		rs, done, changed := j.records[n:], j.Finished != nil, j.changed
+		j.Unlock()
+		n += len(rs)
+
+		// Send it
+		for _, o := range rs {
+			b, _ := json.Marshal(o)
+			if sse {
+				fmt.Fprintf(w, "data: %s\n\n", b)
+			} else {
+				fmt.Fprintf(w, "%s\n", b)
+			}
+		}
+		if fl != nil {
+			fl.Flush()
+		}
+
+		// Stop once the job is done, otherwise wait for more
+		if done {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+
+	}
+
+}
+
+// apiError writes a JSON error response
+func apiError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	b, _ := json.Marshal(map[string]string{"error": msg})
+	w.Write(append(b, '\n'))
+}
@@ -8,22 +8,39 @@
 package shortscan
 
 import (
+	"io"
 	"os"
 	"fmt"
 	"sync"
 	"time"
 	"bufio"
+	"errors"
 	"embed"
+	"sort"
 	"regexp"
+	"context"
 	"strings"
+	"strconv"
+	"path/filepath"
+	"text/template"
+	"hash/fnv"
+	"compress/gzip"
+	"compress/zlib"
+	"compress/flate"
 	"math/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
 	"encoding/json"
+	"net"
 	"net/http"
-	"net/http/httputil"
+	"runtime"
+	"runtime/pprof"
 	"github.com/fatih/color"
 	"github.com/alexflint/go-arg"
 	"github.com/bitquark/shortscan/pkg/maths"
+	"golang.org/x/net/proxy"
+	"github.com/andybalholm/brotli"
 	"github.com/bitquark/shortscan/pkg/shortutil"
 	"github.com/bitquark/shortscan/pkg/levenshtein"
 	log "github.com/sirupsen/logrus"
@@ -31,18 +48,50 @@ import (
 )
 
 type baseRequest struct {
-	url   string
-	file  string
-	tilde string
-	ext   string
+	url      string
+	parent   string
+	file     string
+	tilde    string
+	ext      string
+	chars    string
+	extKnown bool
 }
 
 type httpStats struct {
 	sync.Mutex
-	bytesTx  int
-	bytesRx  int
-	requests int
-	retries  int
+	bytesTx      int
+	bytesRx      int
+	requests     int
+	retries      int
+	errors       int
+	failures     int
+	timeouts     int
+	pauseUntil   time.Time
+	window       []bool
+	windowPos    int
+	windowErrors int
+	abortHost    context.CancelFunc
+	latency      time.Duration
+	budget       *requestBudget
+}
+
+type requestBudget struct {
+	sync.Mutex
+	max       int
+	used      int
+	exhausted bool
+	cancel    context.CancelFunc
+}
+
+type limiter struct {
+	sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	max      int
+	active   int
+	healthy  int
+	errors   int
+	adaptive bool
 }
 
 type markers struct {
@@ -50,60 +99,277 @@ type markers struct {
 	statusNeg int
 }
 
+type sampleResponse struct {
+	status int
+	body   []byte
+}
+
+type wildcards struct {
+	star  string
+	qmark string
+}
+
 type distances struct {
 	distance float32
 	body     string
 }
 
+type statusSample struct {
+	statuses map[int]struct{}
+	uses     int
+	sampled  time.Time
+}
+
+type distanceSample struct {
+	dists   map[int]distances
+	uses    int
+	sampled time.Time
+}
+
+type baselineCache struct {
+	sync.Mutex `json:"-"`
+	Statuses   map[string]savedStatuses  `json:"statuses"`
+	Distances  map[string]savedDistances `json:"distances"`
+}
+
+type savedStatuses struct {
+	Statuses []int     `json:"statuses"`
+	Sampled  time.Time `json:"sampled"`
+}
+
+type savedDistances struct {
+	Distances map[int]savedDistance `json:"distances"`
+	Sampled   time.Time             `json:"sampled"`
+}
+
+type savedDistance struct {
+	Distance float32 `json:"distance"`
+	Body     string  `json:"body"`
+}
+
+type charset struct {
+	fileChars map[string]string
+	extChars  map[string]string
+}
+
 type wordlistRecord struct {
 	checksums   string
 	filename    string
 	extension   string
 	filename83  string
 	extension83 string
+	priority    int
 }
 
+// The wordlist and its indexes are read-only once loaded, so can be shared between goroutines without locking
 type wordlistConfig struct {
-	wordlist  []wordlistRecord
-	isRainbow bool
-	sync.Mutex
+	wordlist   []wordlistRecord
+	byStem     map[string][]wordlistRecord
+	byChecksum map[string][]wordlistRecord
+	isRainbow  bool
 }
 
 type attackConfig struct {
-	method            string
-	suffix            string
-	tildes            []string
-	fileChars         map[string]string
-	extChars          map[string]string
-	foundFiles        map[string]struct{}
-	foundDirectories  []string
-	wordlist          wordlistConfig
-	distanceMutex     sync.Mutex
-	autocompleteMutex sync.Mutex
+	method           string
+	suffix           string
+	wc               wildcards
+	autocomplete     string
+	rng              *rand.Rand
+	tildes           []string
+	fileChars        map[string]string
+	extChars         map[string]string
+	foundFiles       map[string]struct{}
+	foundDirectories []string
+	wordlist         *wordlistConfig
+	results          []resultOutput
+	stems            map[string]map[string]struct{}
+	statusCache      map[string]*statusSample
+	distanceCache    map[string]*distanceSample
+	hits             map[string]map[rune]int
+	fileCount        int
+	dirCount         int
+	partialCount     int
+	sampleLocks      map[string]*sync.Mutex
+	statusMutex      sync.Mutex
+	distanceMutex    sync.Mutex
+	sampleMutex      sync.Mutex
+	foundMutex       sync.Mutex
+	resultMutex      sync.Mutex
+	hitMutex         sync.Mutex
+}
+
+// Scanner holds everything needed to scan a URL, so independent scanners can coexist in one process
+type Scanner struct {
+	opts            Options
+	out             io.Writer
+	client          *http.Client
+	followClient    *http.Client
+	wordlist        *wordlistConfig
+	headerTemplates map[string]*template.Template
+	extensions      []string
+	shortNames      []baseRequest
+	baselines       *baselineCache
+	requestLog      *requestLogger
+	rng             *rand.Rand
+	onResult        func(Result)
+	onStatus        func(Status)
+}
+
+// Options configures a Scanner (these are the command-line arguments; see DefaultOptions)
+type Options = arguments
+
+// Result is a single short name found by a Scanner
+type Result = resultOutput
+
+// Status reports whether a URL scanned by a Scanner was reachable and vulnerable
+type Status = statusOutput
+
+type byteCounter int
+
+type lockedSource struct {
+	sync.Mutex
+	src rand.Source64
 }
 
 type resultOutput struct {
-	Type      string `json:"type"`
-	FullMatch bool   `json:"fullmatch"`
-	BaseUrl   string `json:"baseurl"`
-	File      string `json:"shortfile"`
-	Ext       string `json:"shortext"`
-	Tilde     string `json:"shorttilde"`
-	Partname  string `json:"partname"`
-	Fullname  string `json:"fullname"`
+	Type           string `json:"type"`
+	Version        string `json:"version"`
+	FullMatch      bool   `json:"fullmatch"`
+	BaseUrl        string `json:"baseurl"`
+	ParentUrl      string `json:"parenturl"`
+	ShortName      string `json:"shortname"`
+	File           string `json:"shortfile"`
+	Ext            string `json:"shortext"`
+	Tilde          string `json:"shorttilde"`
+	Partname       string `json:"partname"`
+	Fullname       string `json:"fullname"`
+	FuzzPattern    string `json:"fuzzpattern"`
+	Confirmed      *bool  `json:"confirmed,omitempty"`
+	CollisionCount int    `json:"collisioncount"`
+	Status         int    `json:"status,omitempty"`
+	ContentLength  *int64 `json:"contentlength,omitempty"`
+	ContentType    string `json:"contenttype,omitempty"`
+	ProbeUrl       string `json:"probeurl,omitempty"`
+	ProbeMethod    string `json:"probemethod,omitempty"`
+}
+
+type resultBuffer struct {
+	sync.Mutex
+	results    []resultOutput
+	statuses   []statusOutput
+	detections []detection
+}
+
+type detection struct {
+	url          string
+	method       string
+	suffix       string
+	autocomplete string
+	wc           wildcards
+	mk           markers
+}
+
+type probeResult struct {
+	status      int
+	length      *int64
+	contentType string
+}
+
+type xmlOutput struct {
+	XMLName xml.Name  `xml:"shortscan"`
+	Version string    `xml:"version,attr"`
+	Hosts   []xmlHost `xml:"host"`
+}
+
+type xmlHost struct {
+	Url         string      `xml:"url,attr"`
+	Server      string      `xml:"server,attr,omitempty"`
+	Vulnerable  bool        `xml:"vulnerable,attr"`
+	Unreachable bool        `xml:"unreachable,attr,omitempty"`
+	IISVersion  string      `xml:"iisversion,attr,omitempty"`
+	Results     []xmlResult `xml:"result"`
+}
+
+type xmlResult struct {
+	Type          string `xml:"type,attr"`
+	ShortName     string `xml:"shortname,attr"`
+	Partname      string `xml:"partname,attr"`
+	Fullname      string `xml:"fullname,attr,omitempty"`
+	Confirmed     *bool  `xml:"confirmed,attr,omitempty"`
+	Status        int    `xml:"status,attr,omitempty"`
+	ContentLength *int64 `xml:"contentlength,attr,omitempty"`
+	ContentType   string `xml:"contenttype,attr,omitempty"`
+	ProbeUrl      string `xml:"probeurl,attr,omitempty"`
+	ProbeMethod   string `xml:"probemethod,attr,omitempty"`
+}
+
+type treeNode struct {
+	label    string
+	children map[string]*treeNode
 }
 
 type statusOutput struct {
-	Type       string `json:"type"`
-	Url        string `json:"url"`
-	Server     string `json:"server"`
-	Vulnerable bool   `json:"vulnerable"`
+	Type        string   `json:"type"`
+	Version     string   `json:"version"`
+	Url         string   `json:"url"`
+	Server      string   `json:"server"`
+	Vulnerable  bool     `json:"vulnerable"`
+	Unreachable bool     `json:"unreachable"`
+	IISVersion  string   `json:"iisversion,omitempty"`
+	IISEvidence []string `json:"iisevidence,omitempty"`
+}
+
+type summaryOutput struct {
+	Type         string `json:"type"`
+	Version      string `json:"version"`
+	Url          string `json:"url"`
+	Files        int    `json:"files"`
+	Directories  int    `json:"directories"`
+	Partials     int    `json:"partials"`
+	Method       string `json:"method"`
+	Suffix       string `json:"suffix"`
+	Autocomplete string `json:"autocomplete"`
+	TimedOut     bool   `json:"timedout"`
+}
+
+type wordlistOutput struct {
+	Type        string         `json:"type"`
+	Version     string         `json:"version"`
+	Entries     int            `json:"entries"`
+	Stems       int            `json:"stems"`
+	Checksummed int            `json:"checksummed"`
+	Extensions  map[string]int `json:"extensions"`
+}
+
+type eventOutput struct {
+	Type    string   `json:"type"`
+	Version string   `json:"version"`
+	Event   string   `json:"event"`
+	Url     string   `json:"url,omitempty"`
+	Urls    []string `json:"urls,omitempty"`
+}
+
+type requestLogger struct {
+	sync.Mutex
+	fh  *os.File
+	enc *json.Encoder
+}
+
+type requestRecord struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Url    string    `json:"url"`
+	Status int       `json:"status"`
+	Error  string    `json:"error,omitempty"`
 }
 
 type statsOutput struct {
 	Type          string `json:"type"`
+	Version       string `json:"version"`
 	Requests      int    `json:"requests"`
 	Retries       int    `json:"retries"`
+	Failures      int    `json:"failures"`
+	Timeouts      int    `json:"timeouts"`
 	SentBytes     int    `json:"sentbytes"`
 	ReceivedBytes int    `json:"receivedbytes"`
 }
@@ -113,6 +379,18 @@ const version = "0.9.2"
 const rainbowMagic = "#SHORTSCAN#"
 const alphanum = "JFKGOTMYVHSPCANDXLRWEBQUIZ8549176320"
 
+// Number of recent requests over which the circuit breaker error rate is calculated
+const errorWindow = 50
+
+// Longest pause to honour when a server asks us to slow down
+const maxPause = 5 * time.Minute
+
+// Number of requests made (at once) to warm up connections and measure latency
+const warmupRequests = 5
+
+// Largest CIDR range that will be expanded into target URLs (a /16)
+const maxCidrHosts = 65536
+
 // Standard headers + IIS DEBUG, ordered roughly by frequency and probable response time
 // https://www.iana.org/assignments/http-methods/http-methods.xhtml#methods
 var httpMethods = [...]string{
@@ -126,32 +404,118 @@ var httpMethods = [...]string{
 // Path suffixes to try
 var pathSuffixes = [...]string{"/", "", "/.aspx", "?aspxerrorpath=/", "/.aspx?aspxerrorpath=/", "/.asmx", "/.vb"}
 
+// Wildcard styles (IIS also understands the DOS wildcards < and >, which some servers, proxies and WAFs
+// let through when they block or mangle * and ?); see Scanner.wildcards for how they're encoded
+var wildcardStyles = map[string]wildcards{
+	"star": {"*", "?"},
+	"dos":  {"<", ">"},
+}
+
+// Interactive results view, only available in builds with the tui tag (see tui.go)
+var runTUI func(s *Scanner, urls []string, mk markers) int
+
 // Embed the default wordlist
 //
 //go:embed resources/wordlist.txt
 var defaultWordlist embed.FS
 
-// Caches and regexes
-var statusCache map[string]map[int]struct{}
-var distanceCache map[string]map[int]distances
-var checksumRegex *regexp.Regexp
+// ErrMaxRequests is returned when a scan stops because it has made the maximum number of requests allowed
+var ErrMaxRequests = errors.New("maximum number of requests reached")
+
+// Regexes
+var checksumRegex = regexp.MustCompile(".{1,2}[0-9A-F]{4}")
+
+// Regular expression to split an 8.3 short name into its filename, tilde and extension (directories may have a trailing slash)
+var shortNameRegex = regexp.MustCompile(`^([^~./\\]{1,6})(~[0-9]+)(\.[^.~/\\]{1,3})?/?$`)
+var iisBannerRegex = regexp.MustCompile(`Microsoft-IIS/(\d+\.\d+)`)
+
+// Regular expression matching runs of characters which aren't safe in per-host output filenames
+var hostFilenameRegex = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// IIS versions in release order, for fingerprinting
+var iisVersions = []string{"5.0", "5.1", "6.0", "7.0", "7.5", "8.0", "8.5", "10.0"}
 
 // Command-line arguments and help
 type arguments struct {
-	Urls         []string `arg:"positional,required" help:"url to scan (multiple URLs can be provided; a file containing URLs can be specified with an «at» prefix, for example: @urls.txt)" placeholder:"URL"`
-	Wordlist     string   `arg:"-w" help:"combined wordlist + rainbow table generated with shortutil" placeholder:"FILE"`
-	Headers      []string `arg:"--header,-H,separate" help:"header to send with each request (use multiple times for multiple headers)"`
-	Concurrency  int      `arg:"-c" help:"number of requests to make at once" default:"20"`
-	Timeout      int      `arg:"-t" help:"per-request timeout in seconds" placeholder:"SECONDS" default:"10"`
-	Output       string   `arg:"-o" help:"output format (human = human readable; json = JSON)" placeholder:"format" default:"human"`
-	Verbosity    int      `arg:"-v" help:"how much noise to make (0 = quiet; 1 = debug; 2 = trace)" default:"0"`
-	FullUrl      bool     `arg:"-F" help:"display the full URL for confirmed files rather than just the filename" default:"false"`
-	NoRecurse    bool     `arg:"-n" help:"don't detect and recurse into subdirectories (disabled when autocomplete is disabled)" default:"false"`
-	Stabilise    bool     `arg:"-s" help:"attempt to get coherent autocomplete results from an unstable server (generates more requests)" default:"false"`
-	Patience     int      `arg:"-p" help:"patience level when determining vulnerability (0 = patient; 1 = very patient)" placeholder:"LEVEL" default:"0"`
-	Characters   string   `arg:"-C" help:"filename characters to enumerate" default:"JFKGOTMYVHSPCANDXLRWEBQUIZ8549176320-_()&'!#$%@^{}~"`
-	Autocomplete string   `arg:"-a" help:"autocomplete detection mode (auto = autoselect; method = HTTP method magic; status = HTTP status; distance = Levenshtein distance; none = disable)" placeholder:"mode" default:"auto"`
-	IsVuln       bool     `arg:"-V" help:"bail after determining whether the service is vulnerable" default:"false"`
+	Urls             []string      `arg:"positional" help:"url to scan (multiple URLs can be provided; a file containing URLs can be specified with an «at» prefix, for example: @urls.txt)" placeholder:"URL"`
+	FromRequest      string        `arg:"--from-request" help:"raw HTTP request file (e.g. saved from Burp) to take headers, cookies and, if no URL is given, the target URL from (assumes https unless the request line has a full URL)" placeholder:"FILE"`
+	CidrTemplate     string        `arg:"--cidr-template" help:"URL template for hosts expanded from CIDR ranges given as URLs (e.g. 10.0.0.0/24), with HOST replaced by each address" placeholder:"URL" default:"https://HOST/"`
+	Wordlist         []string      `arg:"-w,separate" help:"combined wordlist + rainbow table generated with shortutil (use multiple times to merge wordlists)" placeholder:"FILE"`
+	Headers          []string      `arg:"--header,-H,separate" help:"header to send with each request (use multiple times for multiple headers; values may use {{.URL}}, {{.Path}}, {{.Method}} and {{.Timestamp}})"`
+	NoWordlist       bool          `arg:"--no-wordlist" help:"don't load a wordlist and only report short names (implies -a none)" default:"false"`
+	HeadersFile      string        `arg:"--headers-file" help:"file of headers to send with each request in Name: Value form, one per line (headers given with -H take precedence)" placeholder:"FILE"`
+	Body             string        `arg:"--body" help:"body to send with each request (e.g. an XML body for PROPFIND; no body is sent by default)" placeholder:"STRING"`
+	BodyFile         string        `arg:"--body-file" help:"file containing the body to send with each request" placeholder:"FILE"`
+	HostsConcurrency int           `arg:"--hosts-concurrency" help:"number of distinct hosts to scan at once (output from different hosts will be interleaved, so -F or JSON output is recommended)" placeholder:"N" default:"1"`
+	Concurrency      int           `arg:"-c" help:"number of requests to make at once" default:"20"`
+	Proxy            string        `arg:"--proxy" help:"proxy to send requests through (http://, https:// or socks5://, with optional user:pass@; defaults to the environment)" placeholder:"URL"`
+	CaCert           string        `arg:"--ca-cert" help:"verify TLS certificates against this CA certificate (PEM) rather than skipping verification (e.g. Burp's CA when using --proxy)" placeholder:"FILE"`
+	Timeout          int           `arg:"-t" help:"per-request timeout in seconds" placeholder:"SECONDS" default:"10"`
+	BodySample       int           `arg:"--body-sample" help:"how many bytes of each response body to keep for comparing responses in distance mode" placeholder:"BYTES" default:"1024"`
+	Warmup           bool          `arg:"--warmup" help:"make a few requests before enumerating each URL to open connections and measure typical latency, warning if the timeout looks too tight" default:"false"`
+	NoCompression    bool          `arg:"--no-compression" help:"ask for uncompressed responses (Accept-Encoding: identity) so response bodies are compared consistently (some servers ignore this, in which case compressed responses are decoded as usual)" default:"false"`
+	Output           string        `arg:"-o" help:"output format (human = human readable; json = JSON; tree = directory tree once finished; xml = XML once finished)" placeholder:"format" default:"human"`
+	OutputDir        string        `arg:"--output-dir" help:"write the output for each host to its own file in this directory rather than to the terminal" placeholder:"DIR"`
+	Seed             int64         `arg:"--seed" help:"seed for the random paths used when probing, so scans can be reproduced (0 = random)" placeholder:"N" default:"0"`
+	Verbosity        int           `arg:"-v" help:"how much noise to make (0 = quiet; 1 = debug; 2 = trace)" default:"0"`
+	TUI              bool          `arg:"--tui" help:"browse results in an interactive terminal view as they are found (needs a build with -tags tui)" default:"false"`
+	Table            bool          `arg:"--table" help:"buffer each URL's results and output them as an aligned table (human output only)" default:"false"`
+	FullUrl          bool          `arg:"-F" help:"display the full URL for confirmed files rather than just the filename" default:"false"`
+	Suggest          bool          `arg:"--suggest" help:"finish with suggested next steps based on what the scan found (human output only)" default:"false"`
+	FollowRedirects  int           `arg:"--follow-redirects" help:"follow up to this many redirects when checking autocomplete candidates (detection and enumeration always see the raw status)" placeholder:"N" default:"0"`
+	NoRecurse        bool          `arg:"-n" help:"don't detect and recurse into subdirectories (disabled when autocomplete is disabled)" default:"false"`
+	Adaptive         bool          `arg:"--adaptive" help:"start with low concurrency and adapt up to the -c limit based on server health (backs off on errors and timeouts)" default:"false"`
+	ErrorAbortRate   float64       `arg:"--error-abort-rate" help:"abort a host and move on when this fraction of its last 50 requests failed (e.g. 0.5; 0 = never)" placeholder:"RATE" default:"0"`
+	Randomise        bool          `arg:"--randomise" help:"randomise the order in which detection methods and suffixes are tried, and the order custom headers are added" default:"false"`
+	Stabilise        bool          `arg:"-s" help:"attempt to get coherent autocomplete results from an unstable server (generates more requests)" default:"false"`
+	Patience         int           `arg:"-p" help:"patience level when determining vulnerability and enumerating (0 = patient; 1 = very patient; 2 = re-probe before pruning during enumeration)" placeholder:"LEVEL" default:"0"`
+	NegSamples       int           `arg:"--negative-samples" help:"number of non-existent URLs to sample when establishing the negative status (0 = 4, or 8 at patience 1 and above)" placeholder:"COUNT" default:"0"`
+	NegThreshold     float64       `arg:"--negative-threshold" help:"fraction of negative samples that must share the most common status for it to be used (0.5 < RATE <= 1; 1 = unanimous)" placeholder:"RATE" default:"1"`
+	WildcardStyle    string        `arg:"--wildcard-style" help:"wildcards to use in probes (auto = standard wildcards, falling back to DOS wildcards if the server doesn't look vulnerable; star = * and ?; dos = < and >, which some servers and WAFs handle differently)" placeholder:"STYLE" default:"auto"`
+	RawWildcards     bool          `arg:"--raw-wildcards" help:"send the ? wildcard literally rather than percent-encoded (some servers and gateways only match one form; a literal ? starts the query string as far as anything in between is concerned)" default:"false"`
+	Method           string        `arg:"--method" help:"skip detection and use this HTTP method (requires --suffix, --status-pos and --status-neg)" placeholder:"METHOD"`
+	Suffix           *string       `arg:"--suffix" help:"skip detection and use this path suffix (may be empty)" placeholder:"SUFFIX"`
+	StatusPos        int           `arg:"--status-pos" help:"skip detection and treat this status as a hit" placeholder:"STATUS"`
+	StatusNeg        int           `arg:"--status-neg" help:"skip detection and treat this status as a miss" placeholder:"STATUS"`
+	MaxNameLen       int           `arg:"--max-name-len" help:"maximum length of the filename part of a short name to enumerate (lower it to save requests at the cost of less complete names)" placeholder:"N" default:"6"`
+	MaxExtLen        int           `arg:"--max-ext-len" help:"maximum length of the extension part of a short name to enumerate" placeholder:"N" default:"3"`
+	Characters       string        `arg:"-C" help:"filename characters to enumerate" default:"JFKGOTMYVHSPCANDXLRWEBQUIZ8549176320-_()&'!#$%@^{}~"`
+	CharsProbeFirst  bool          `arg:"--characters-probe-first" help:"probe all characters against the first tilde only, then just its hits against higher tildes (fewer requests, but may miss files whose lower tilde sibling was deleted)" default:"false"`
+	ReuseCharset     bool          `arg:"--reuse-charset" help:"reuse the character set found on a host when recursing into its directories (fewer requests, but characters only used in a subdirectory will be missed unless nothing at all is found)" default:"false"`
+	HitOrder         bool          `arg:"--hit-order" help:"try the characters that have produced the most hits so far at each tilde level first (can prune branches sooner on servers where a few characters dominate)" default:"false"`
+	ResampleInterval int           `arg:"--resample-interval" help:"take fresh autocomplete baseline samples after they've been used this many times (0 = never)" placeholder:"N" default:"0"`
+	ResampleAge      time.Duration `arg:"--resample-age" help:"take fresh autocomplete baseline samples once they're this old (e.g. 5m; 0 = never)" placeholder:"DURATION" default:"0"`
+	CacheFile        string        `arg:"--cache-file" help:"file to load autocomplete baselines from and save them to, so repeat scans of the same URLs can skip sampling" placeholder:"FILE"`
+	CacheTTL         time.Duration `arg:"--cache-ttl" help:"discard saved autocomplete baselines older than this" placeholder:"DURATION" default:"24h"`
+	Autocomplete     string        `arg:"-a" help:"autocomplete detection mode (auto = autoselect; method = HTTP method magic; status = HTTP status; distance = Levenshtein distance; none = disable)" placeholder:"mode" default:"auto"`
+	MaxCandidates    int           `arg:"--max-candidates" help:"maximum number of autocomplete candidates to try for each short name, most likely first (0 = no limit)" placeholder:"N" default:"0"`
+	Fingerprint      bool          `arg:"--fingerprint" help:"estimate the IIS version from the server headers and how it handles detection probes (a best guess, reported with the evidence used)" default:"false"`
+	IsVuln           bool          `arg:"-V" help:"bail after determining whether the service is vulnerable" default:"false"`
+	RecurseShort     bool          `arg:"--recurse-short" help:"also recurse into directories identified by their short name when the full name can't be autocompleted" default:"false"`
+	ShortNames       string        `arg:"--shortnames" help:"skip detection and enumeration and just autocomplete the 8.3 names listed in this file (one per line, e.g. DEFAUL~1.ASP) on each URL" placeholder:"FILE"`
+	ExtensionsList   string        `arg:"--extensions-wordlist" help:"file or comma-separated list of extensions to try directly once a filename is found, instead of enumerating extensions character by character" placeholder:"FILE|LIST"`
+	ExtFirst         bool          `arg:"--ext-first" help:"find the extensions in use for each tilde level first, then enumerate filenames for each extension (can save requests when there are few extensions but long filenames)" default:"false"`
+	NoExt            bool          `arg:"--no-ext" help:"don't enumerate extensions, only report filename short names" default:"false"`
+	ExpandExt        bool          `arg:"--expand-ext" help:"when autocomplete fails, also try the discovered stem with each extension from --expand-ext-list" default:"false"`
+	ExpandExtList    string        `arg:"--expand-ext-list" help:"comma-separated extensions to try with --expand-ext" placeholder:"LIST" default:"bak,old,config,txt,zip"`
+	StrictWordlist   bool          `arg:"--strict-wordlist" help:"abort on invalid rainbow table entries rather than skipping them" default:"false"`
+	WordlistStats    bool          `arg:"--wordlist-stats" help:"output wordlist coverage statistics before scanning" default:"false"`
+	ConfirmShort     bool          `arg:"--confirm-short" help:"confirm each short name by requesting it directly and note whether it resolved (generates more requests)" default:"false"`
+	ProbeConfirmed   bool          `arg:"--probe-confirmed" help:"request each resolved full filename and record its status, size and content type (generates more requests)" default:"false"`
+	Evidence         bool          `arg:"--evidence" help:"include the request (method and URL) which matched each short name in the results, so findings can be verified independently (always included at verbosity 1 and above)" default:"false"`
+	OnlyDirs         bool          `arg:"--only-dirs" help:"only output directories (enumeration still runs in full)" default:"false"`
+	OnlyFiles        bool          `arg:"--only-files" help:"only output files (enumeration still runs in full)" default:"false"`
+	QuietErrors      bool          `arg:"--quiet-errors" help:"only log transient per-request errors (timeouts, dropped connections, etc.) at trace verbosity" default:"false"`
+	RequestLog       string        `arg:"--request-log" help:"write a line of JSON for every request made (method, URL and status) to this file" placeholder:"FILE"`
+	MaxRequests      int           `arg:"--max-requests" help:"maximum number of requests to make over the whole scan (including retries), after which partial results are reported (0 = no limit)" placeholder:"N" default:"0"`
+	HostTimeout      time.Duration `arg:"--host-timeout" help:"maximum time to spend on each URL given (including its subdirectories), after which partial results are reported and the scan moves on (e.g. 5m; 0 = no limit)" placeholder:"DURATION" default:"0"`
+	MaxDuration      time.Duration `arg:"--max-duration" help:"maximum time to spend on the whole scan, after which partial results are reported (e.g. 30m; 0 = no limit)" placeholder:"DURATION" default:"0"`
+	Serve            string        `arg:"--serve" help:"run an HTTP API on this address (e.g. 127.0.0.1:8080, or :8080 for localhost) which starts scans on request, instead of scanning the given URLs" placeholder:"ADDR"`
+	ServeJobs        int           `arg:"--serve-jobs" help:"number of scans the HTTP API runs at once (others are queued)" placeholder:"N" default:"2"`
+	ServeToken       string        `arg:"--serve-token,env:SHORTSCAN_SERVE_TOKEN" help:"require HTTP API requests to send this bearer token in an Authorization header" placeholder:"TOKEN"`
+	ServeTTL         time.Duration `arg:"--serve-ttl" help:"how long the HTTP API keeps a finished scan and its results before forgetting them (e.g. 30m)" placeholder:"DURATION" default:"1h"`
+	CpuProfile       string        `arg:"--cpuprofile" help:"write a CPU profile (for go tool pprof) covering the scan to this file" placeholder:"FILE"`
+	MemProfile       string        `arg:"--memprofile" help:"write a heap profile (for go tool pprof) to this file once the scan finishes" placeholder:"FILE"`
 }
 
 func (arguments) Version() string {
@@ -160,891 +524,3869 @@ func (arguments) Version() string {
 
 var args arguments
 
-// getBanner returns the main banner
-func getBanner() string {
-	return color.New(color.FgBlue, color.Bold).Sprint("🌀 Shortscan v"+version) + " · " + color.New(color.FgWhite, color.Bold).Sprint("an IIS short filename enumeration tool by bitquark")
+// DefaultOptions returns the options a Scanner would be given on the command line with no flags (with output disabled)
+func DefaultOptions() Options {
+
+	var o Options
+	p, err := arg.NewParser(arg.Config{}, &o)
+	if err == nil {
+		err = p.Parse(nil)
+	}
+	if err != nil {
+		panic(err)
+	}
+	o.Output = "none"
+	return o
+
 }
 
-// pathEscape returns an escaped URL with spaces encoded as %20 rather than + (which can cause odd behaviour from IIS in some modes)
-func pathEscape(url string) string {
-	return strings.Replace(nurl.QueryEscape(url), "+", "%20", -1)
+// normaliseOptions tidies up options which can be given in more than one form
+func normaliseOptions(o *Options) {
+	o.Autocomplete = strings.ToLower(o.Autocomplete)
+	o.Output = strings.ToLower(o.Output)
+	if o.NoWordlist && len(o.Wordlist) == 0 {
+		o.Autocomplete = "none"
+	}
 }
 
-// fetch requests the given URL and returns an HTTP response object, handling retries gracefully
-func fetch(hc *http.Client, st *httpStats, method string, url string) (*http.Response, error) {
+// validateOptions checks options which would otherwise cause a scan to misbehave (or panic)
+func validateOptions(o Options) error {
 
-	// Create a request object
-	req, err := http.NewRequest(method, url, nil)
-	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Fatal("Unable to create request object")
+	if o.Autocomplete != "auto" && o.Autocomplete != "method" && o.Autocomplete != "status" && o.Autocomplete != "distance" && o.Autocomplete != "none" {
+		return errors.New("autocomplete must be one of: auto, status, method, none")
+	}
+	if o.NoWordlist && len(o.Wordlist) > 0 {
+		return errors.New("only one of --no-wordlist and -w can be used")
+	}
+	if o.Output != "human" && o.Output != "json" && o.Output != "tree" && o.Output != "xml" && o.Output != "none" {
+		return errors.New("output must be one of: human, json, tree, xml")
+	}
+	if o.OnlyDirs && o.OnlyFiles {
+		return errors.New("only one of --only-dirs and --only-files can be used")
+	}
+	if n := btoi(o.Method != "") + btoi(o.Suffix != nil) + btoi(o.StatusPos != 0) + btoi(o.StatusNeg != 0); n > 0 && n < 4 {
+		return errors.New("--method, --suffix, --status-pos and --status-neg must all be given together")
+	}
+	if o.StatusPos != 0 && o.StatusPos == o.StatusNeg {
+		return errors.New("--status-pos and --status-neg must differ")
+	}
+	if o.NoExt && o.ExtensionsList != "" {
+		return errors.New("only one of --no-ext and --extensions-wordlist can be used")
+	}
+	if o.FollowRedirects < 0 {
+		return errors.New("the number of redirects to follow can't be negative")
+	}
+	if o.BodySample < 0 {
+		return errors.New("body sample size can't be negative")
+	}
+	if o.MaxRequests < 0 {
+		return errors.New("the maximum number of requests can't be negative")
+	}
+	if _, ok := wildcardStyles[o.WildcardStyle]; !ok && o.WildcardStyle != "auto" {
+		return errors.New("wildcard style must be one of: auto, star, dos")
+	}
+	if o.ShortNames != "" && o.Autocomplete == "none" {
+		return errors.New("--shortnames needs autocomplete, so can't be combined with -a none")
+	}
+	if o.MaxNameLen < 1 || o.MaxExtLen < 1 {
+		return errors.New("the maximum name and extension lengths must be at least 1")
 	}
+	if o.MaxCandidates < 0 {
+		return errors.New("the maximum number of autocomplete candidates can't be negative")
+	}
+	if o.ExtFirst && o.NoExt {
+		return errors.New("--ext-first can't be combined with --no-ext")
+	}
+	if o.Concurrency < 1 {
+		return errors.New("concurrency must be at least 1")
+	}
+	if o.HostsConcurrency < 1 {
+		return errors.New("hosts concurrency must be at least 1")
+	}
+	if o.NegThreshold <= 0.5 || o.NegThreshold > 1 {
+		return errors.New("negative threshold must be greater than 0.5 and at most 1")
+	}
+	if o.ServeTTL < 0 {
+		return errors.New("--serve-ttl can't be negative")
+	}
+	if o.ServeJobs < 1 {
+		return errors.New("the number of API jobs must be at least 1")
+	}
+	return nil
 
-	// Default user agent
-	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/1337.00 (KHTML, like Gecko) Chrome/1337.0.0.0 Safari/1337.00")
+}
 
-	// Loop through custom headers
-	for _, h := range args.Headers {
+// NewScanner creates a Scanner with the given options and HTTP client, loading its wordlists up front
+func NewScanner(opts Options, hc *http.Client) (*Scanner, error) {
 
-		// Split the header (the alternative is to use textproto.ReadMIMEHeader(), but that's more involved)
-		hs := strings.SplitN(h, ":", 2)
-		if len(hs) != 2 {
-			log.WithFields(log.Fields{"header": h}).Fatal("Invalid header")
-		}
+	// Check the options make sense (after tidying them up as the command line does)
+	normaliseOptions(&opts)
+	if err := validateOptions(opts); err != nil {
+		return nil, err
+	}
 
-		// Add the header (host requires handling a little differently)
-		h, v := strings.Trim(hs[0], " "), strings.Trim(hs[1], " ")
-		if strings.ToLower(h) == "host" {
-			req.Host = v
-		} else {
-			req.Header.Add(h, v)
+	// Default to a client that doesn't follow redirects, which the checks rely on
+	if hc == nil {
+		tr, err := getTransport(opts)
+		if err != nil {
+			return nil, err
+		}
+		hc = &http.Client{
+			Timeout:       time.Duration(opts.Timeout) * time.Second,
+			Transport:     tr,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
 		}
+	}
+	s := &Scanner{opts: opts, out: os.Stdout, client: hc, followClient: hc, wordlist: &wordlistConfig{}, headerTemplates: make(map[string]*template.Template)}
 
+	// Give the scanner its own random number generator (seeded deterministically if requested) rather than touching
+	// the global one, which may belong to whatever embeds it
+	seed := time.Now().UTC().UnixNano()
+	if opts.Seed != 0 {
+		seed = opts.Seed
+	}
+	s.rng = newLockedRand(seed)
+
+	// Autocomplete requests can follow a limited number of redirects if requested
+	if opts.FollowRedirects > 0 {
+		fc := *hc
+		fc.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if len(via) > opts.FollowRedirects {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		}
+		s.followClient = &fc
 	}
 
-	// Request loop
-	var t int
-	var rerr error
-	var res *http.Response
-	for t = 0; t < 4; t++ {
+	// Check headers are valid and compile any templated values (plain values are used as-is)
+	for _, h := range opts.Headers {
+		hs := strings.SplitN(h, ":", 2)
+		if len(hs) != 2 {
+			return nil, fmt.Errorf("invalid header: %s", h)
+		}
+		if !strings.Contains(hs[1], "{{") {
+			continue
+		}
+		v := strings.Trim(hs[1], " ")
+		t, err := template.New("header").Option("missingkey=error").Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid header template: %w", err)
+		}
+		s.headerTemplates[v] = t
+	}
 
-		// Make the request and break the loop if everything went well
-		res, rerr = hc.Do(req)
-		if err == nil {
-			break
+	// Read the selected wordlists into memory (unless autocomplete is disabled, in which case they'd go unused)
+	if opts.Autocomplete == "none" {
+		if len(opts.Wordlist) > 0 {
+			log.Warn("Autocomplete is disabled, so the custom wordlist won't be used")
+		}
+		log.Info("Running without a wordlist")
+	} else if len(opts.Wordlist) > 0 {
+		for _, w := range opts.Wordlist {
+			log.WithFields(log.Fields{"file": w}).Info("Using custom wordlist")
+			fh, err := os.Open(w)
+			if err != nil {
+				return nil, fmt.Errorf("unable to open wordlist: %w", err)
+			}
+			err = loadWordlist(s.wordlist, bufio.NewScanner(fh), w, opts.StrictWordlist)
+			fh.Close()
+			if err != nil {
+				return nil, err
+			}
 		}
+	} else {
+		log.Info("Using built-in wordlist")
+		fh, _ := defaultWordlist.Open("resources/wordlist.txt")
+		if err := loadWordlist(s.wordlist, bufio.NewScanner(fh), "built-in", opts.StrictWordlist); err != nil {
+			return nil, err
+		}
+	}
 
-		// Back off and retry if there was an error
-		d := time.Duration(t*2) * time.Second
-		log.WithFields(log.Fields{"err": rerr}).Trace(fmt.Sprintf("fetch() failed, retrying in %s", d))
-		time.Sleep(d)
+	// Index the wordlist and report coverage
+	indexWordlist(s.wordlist)
+	s.reportWordlist()
 
+	// Read the extension list, converting each extension to its 8.3 form
+	if opts.ExtensionsList != "" {
+		el, err := readExtensions(opts.ExtensionsList)
+		if err != nil {
+			return nil, err
+		}
+		s.extensions = el
+		log.WithFields(log.Fields{"extensions": el}).Info("Using extension list")
 	}
 
-	// Return the last error if there's no result
-	if res == nil {
-		return nil, rerr
+	// Read the short names to autocomplete
+	if opts.ShortNames != "" {
+		sn, err := readShortNames(opts.ShortNames)
+		if err != nil {
+			return nil, err
+		}
+		s.shortNames = sn
+		log.WithFields(log.Fields{"file": opts.ShortNames, "names": len(sn)}).Info("Using short name list")
 	}
 
-	// Debug
-	log.WithFields(log.Fields{"method": method, "url": url, "status": res.StatusCode}).Trace("fetch()")
-
-	// Update request stats
-	st.Lock()
-	st.requests++
-	st.retries += t
-	if r, err := httputil.DumpRequestOut(req, true); err == nil {
-		st.bytesTx += len(r)
-	} else {
-		log.WithFields(log.Fields{"err": err}).Fatal("Error dumping request")
+	// Load saved autocomplete baselines
+	if opts.CacheFile != "" {
+		bc, err := loadBaselines(opts.CacheFile, opts.CacheTTL)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load cache file: %w", err)
+		}
+		s.baselines = bc
 	}
-	if r, err := httputil.DumpResponse(res, true); err == nil {
-		st.bytesRx += len(r)
-	} else {
-		log.WithFields(log.Fields{"err": err}).Fatal("Error dumping response")
+
+	// Open the request log
+	if opts.RequestLog != "" {
+		fh, err := os.Create(opts.RequestLog)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create request log: %w", err)
+		}
+		s.requestLog = &requestLogger{fh: fh, enc: json.NewEncoder(fh)}
 	}
-	st.Unlock()
 
-	// Closing the response body allows the connection to be reused
-	res.Body.Close()
+	return s, nil
 
-	// Return the result
-	return res, nil
+}
 
+// OnResult sets a function to call with each result as soon as it's found (before the collision count is final);
+// it may be called from several goroutines at once
+func (s *Scanner) OnResult(f func(Result)) {
+	s.onResult = f
 }
 
-// enumerate builds and fetches candidate short name URLs making use of recursion
-func enumerate(sem chan struct{}, wg *sync.WaitGroup, hc *http.Client, st *httpStats, ac *attackConfig, mk markers, br baseRequest) {
+// OnStatus sets a function to call with the status of each URL once its vulnerability check is done
+func (s *Scanner) OnStatus(f func(Status)) {
+	s.onStatus = f
+}
 
-	// Extension enumeration mode
-	extMode := len(br.ext) > 0
+// Close saves autocomplete baselines (if a cache file is in use) and closes the request log
+func (s *Scanner) Close() error {
 
-	// Select the character map to use
-	var chars string
-	if extMode {
-		chars = ac.extChars[br.tilde]
-	} else {
-		chars = ac.fileChars[br.tilde]
+	// Save autocomplete baselines for next time
+	if s.baselines != nil {
+		if err := saveBaselines(s.opts.CacheFile, s.baselines); err != nil {
+			return fmt.Errorf("unable to save cache file: %w", err)
+		}
 	}
 
-	// Loop through characters
-	for _, char := range chars {
+	// Close the request log
+	if s.requestLog != nil {
+		return s.requestLog.fh.Close()
+	}
 
-		// Increment the waitgroup
-		wg.Add(1)
+	return nil
 
-		// Check goroutine
-		go func(sem chan struct{}, wg *sync.WaitGroup, hc *http.Client, ac *attackConfig, mk markers, br baseRequest, char string) {
+}
 
-			// Waitgroup and semaphore handling
-			sem <- struct{}{}
-			defer func(sem chan struct{}, wg *sync.WaitGroup) {
-				<-sem
-				wg.Done()
-			}(sem, wg)
+// getBanner returns the main banner
+func getBanner() string {
+	return color.New(color.FgBlue, color.Bold).Sprint("🌀 Shortscan v"+version) + " · " + color.New(color.FgWhite, color.Bold).Sprint("an IIS short filename enumeration tool by bitquark")
+}
 
-			// Workaround for an IIS bug which makes the two characters following a percent sign
-			// in the 0-F range always return a match (so we just skip them)
-			if char == "%" {
-				var x, y int
-				if extMode {
-					x, y = len(br.ext), 1
-				} else {
-					x, y = len(br.file), 4
-				}
-				for i := 0; i < 2 && x < y; i++ {
-					char += "?"
-				}
-			}
+// pathEscape returns an escaped URL with spaces encoded as %20 rather than + (which can cause odd behaviour from IIS in some modes)
+func pathEscape(url string) string {
+	return strings.Replace(nurl.QueryEscape(url), "+", "%20", -1)
+}
 
-			// Add the next character and build the initial check URL
-			var url string
-			if extMode {
-				br.ext += char
-				url = br.url + pathEscape(br.file) + br.tilde + pathEscape(br.ext) + "*" + ac.suffix
-			} else {
-				br.file += char
-				url = br.url + pathEscape(br.file) + "*" + br.tilde + "*" + pathEscape(br.ext) + ac.suffix
-			}
+// wildcards returns the given wildcard style encoded for use in probe URLs: * is always sent as-is, while ?, < and >
+// are percent-encoded unless raw wildcards were requested (note that a raw ? starts the query string, and that Go
+// re-encodes raw < and > on the wire, so raw mode only really changes the ? wildcard)
+func (s *Scanner) wildcards(style string) wildcards {
 
-			// Check whether this looks like a hit
-			res, err := fetch(hc, st, ac.method, url)
-			if err == nil && res.StatusCode == mk.statusPos {
+	wc := wildcardStyles[style]
+	if s.opts.RawWildcards {
+		return wc
+	}
+	enc := func(w string) string {
+		if w == "*" {
+			return w
+		}
+		return strings.ToLower(pathEscape(w))
+	}
+	return wildcards{enc(wc.star), enc(wc.qmark)}
 
-				// Check whether this is the full file part
-				res, err := fetch(hc, st, ac.method, br.url+pathEscape(br.file)+br.tilde+"*"+pathEscape(br.ext)+ac.suffix)
-				if err == nil && res.StatusCode == mk.statusPos {
+}
 
-					// Check whether there's an extension (some servers return a different status (e.g. 500 Internal Server Error)
-					// when the full name matches, so this final check is loosened to a negative match so we don't miss anything)
-					res, err := fetch(hc, st, ac.method, br.url+pathEscape(br.file)+br.tilde+pathEscape(br.ext)+ac.suffix)
-					if err == nil && res.StatusCode != mk.statusNeg {
+// baseUrl validates the given URL and normalises it into a base URL for enumeration, which may be the
+// web root or any subpath below it (a protocol is added if missing, the query string and fragment are
+// dropped, and the path is given a trailing slash so that short names and directories can be appended)
+func baseUrl(url string) (string, error) {
 
-						// If autocomplete is enabled
-						var fnr, method string
-						if args.Autocomplete != "none" {
+	// Default to HTTPS if no protocol was supplied
+	if !strings.Contains(url, "://") {
+		url = "https://" + url
+	}
 
-							// Look up candidate filenames if the file looks like a checkummed alias (e.g. A5FAB~1.HTM) and a rainbow table was provided
-							var fnc []wordlistRecord
-							if cm := ac.wordlist.isRainbow && checksumRegex.MatchString(br.file); cm {
-								fnc = autodechecksum(ac, br)
-							}
+	// Parse the URL
+	u, err := nurl.Parse(url)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("no host in URL")
+	}
 
-							// Create and add wordlist-based candidates
-							fnc = append(fnc, autocomplete(ac, br)...)
+	// Drop anything that would end up after the appended short name
+	u.RawQuery, u.Fragment, u.RawFragment = "", "", ""
 
-							// Choose the request method
-							if args.Autocomplete == "method" {
-								method = "_"
-							} else {
-								method = "GET"
-							}
+	// Make sure the path is a directory
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+		if u.RawPath != "" {
+			u.RawPath += "/"
+		}
+	}
 
-							// Loop through each filename candidate
-							for _, c := range fnc {
+	return u.String(), nil
 
-								// Encapsulated to simplify mutex handling
-								func() {
+}
 
-									// Lock the mutex
-									ac.autocompleteMutex.Lock()
-									defer ac.autocompleteMutex.Unlock()
+// logRequest writes a record of a request to the request log if one is enabled
+func (s *Scanner) logRequest(method string, url string, res *http.Response, err error) {
 
-									// Set the path
-									path := pathEscape(c.filename + c.extension)
+	// Bail if there's no request log
+	if s.requestLog == nil {
+		return
+	}
 
-									// Skip this filename if it collides with a known discovery
-									if _, ok := ac.foundFiles[path]; ok {
-										return
-									}
+	// Build the record
+	r := requestRecord{Time: time.Now(), Method: method, Url: url}
+	if res != nil {
+		r.Status = res.StatusCode
+	} else if err != nil {
+		r.Error = err.Error()
+	}
 
-									// Make a request to the candidate URL
-									res, err := fetch(hc, st, method, br.url+path)
+	// Write it out
+	s.requestLog.Lock()
+	defer s.requestLog.Unlock()
+	if err := s.requestLog.enc.Encode(r); err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Unable to write to request log")
+	}
 
-									// Skip this check if there was an error
-									if err != nil {
-										log.WithFields(log.Fields{"err": err, "method": method, "url": br.url + path}).Info("Existence check error")
-										return
-									}
+}
 
-									// Branch based on autocomplete mode
-									if args.Autocomplete == "method" {
+// Write counts the bytes written
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
 
-										// When an invalid HTTP method is sent, a "405 Method Not Allowed" response from IIS indicates that a file
-										// exists; this check is less noisy (and often more reliable) than methods such as status or distance checks
-										if res.StatusCode == 405 {
-											fnr = path
-										}
+// newBudget returns a request budget for the scan (nil if unlimited) and a context which is cancelled when it runs out
+func (s *Scanner) newBudget(ctx context.Context) (*requestBudget, context.Context, context.CancelFunc) {
+	if s.opts.MaxRequests <= 0 {
+		return nil, ctx, func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &requestBudget{max: s.opts.MaxRequests, cancel: cancel}, ctx, cancel
+}
 
-									} else if args.Autocomplete == "status" {
+// take uses up a request from the budget, cancelling the scan and returning false once it has run out
+func (b *requestBudget) take() bool {
 
-										// Check the response doesn't appear in this candidate's negative status set
-										ss := getStatuses(c, br, hc, st)
+	// No budget means no limit
+	if b == nil {
+		return true
+	}
 
-										if _, e := ss[res.StatusCode]; !e {
-											fnr = path
-										}
+	b.Lock()
+	defer b.Unlock()
+	if b.used >= b.max {
+		if !b.exhausted {
+			b.exhausted = true
+			b.cancel()
+		}
+		return false
+	}
+	b.used++
+	return true
 
-									} else if args.Autocomplete == "distance" {
+}
 
-										// Get distances for this candidate
-										dists := getDistances(c, br, hc, st, ac)
+// decodeBody wraps a response body in a decoder for its Content-Encoding (Go only decompresses responses itself
+// when it added the Accept-Encoding header, which custom headers can prevent), returning the body as-is if the
+// encoding isn't recognised or the data doesn't look like it's encoded
+func decodeBody(enc string, r *bufio.Reader) io.Reader {
 
-										// If the status code wasn't seen during sampling
-										if dists[res.StatusCode] == (distances{}) {
-											log.WithFields(log.Fields{"url": br.url + path, "status": res.StatusCode}).Info("Autocomplete got a status code hit")
-											fnr = path
-										} else {
+	switch strings.ToLower(strings.TrimSpace(enc)) {
 
-											// Calculate Levenshtein distance between the response and the sample response
-											b := make([]byte, 1024)
-											res.Body.Read(b)
-											body, sbody := string(b), dists[res.StatusCode].body
-											lp := float32(levenshtein.Distance(sbody, body)) / float32(maths.Max(len(sbody), len(body)))
+	case "gzip", "x-gzip":
+		if m, err := r.Peek(2); err == nil && m[0] == 0x1f && m[1] == 0x8b {
+			if z, err := gzip.NewReader(r); err == nil {
+				return z
+			}
+		}
 
-											// If the distance delta is more than 10%
-											d := lp - dists[res.StatusCode].distance
-											if d > 0.1 {
-												log.WithFields(log.Fields{"url": br.url + path, "distance": lp, "delta": d}).Info("Autocomplete got a distance hit")
-												fnr = path
-											}
+	// Deflate should be zlib wrapped, but some servers send raw deflate data
+	case "deflate":
+		if m, err := r.Peek(2); err == nil && m[0]&0x0f == 8 && (int(m[0])<<8|int(m[1]))%31 == 0 {
+			if z, err := zlib.NewReader(r); err == nil {
+				return z
+			}
+		}
+		return flate.NewReader(r)
 
-										}
+	case "br":
+		return brotli.NewReader(r)
 
-									} else {
+	}
 
-										// Bail if args.Autocomplete is unrecognised (this should never happen)
-										log.Fatal("What are you doing here?")
+	return r
 
-									}
+}
 
-									// If a full filename was found
-									if fnr != "" {
-
-										// Add the autocomplete filename to the list
-										ac.foundFiles[fnr] = struct{}{}
+// logRequestError logs a transient per-request error, only at trace level if errors should be kept quiet
+func (s *Scanner) logRequestError(f log.Fields, msg string) {
+	if s.opts.QuietErrors {
+		log.WithFields(f).Trace(msg)
+	} else {
+		log.WithFields(f).Info(msg)
+	}
+}
 
-										// If recursion is enabled
-										if !args.NoRecurse {
+// retryAfter parses a Retry-After header (either delay seconds or an HTTP date), capping the delay at maxPause
+func retryAfter(h string) (time.Duration, bool) {
+	var d time.Duration
+	if s, err := strconv.Atoi(strings.TrimSpace(h)); err == nil && s >= 0 {
+		d = time.Duration(s) * time.Second
+	} else if t, err := http.ParseTime(h); err == nil {
+		d = time.Until(t)
+	} else {
+		return 0, false
+	}
+	return time.Duration(maths.Max(0, maths.Min(int(d), int(maxPause)))), true
+}
 
-											// Make a HEAD request to the autocompleted URL
-											res, err := fetch(hc, st, "HEAD", br.url+fnr)
-											if err != nil {
-												log.WithFields(log.Fields{"err": err, "method": "HEAD", "url": br.url + fnr}).Info("Directory recursion check error")
-											} else {
+// recordOutcome adds a request outcome to the rolling error window and, if the error rate over the window
+// exceeds the configured limit, trips the circuit breaker to abort the current host
+func (s *Scanner) recordOutcome(st *httpStats, url string, failed bool) {
 
-												// Check whether this looks like a directory redirect
-												if l := res.Header.Get("Location"); strings.HasSuffix(strings.ToLower(l), "/"+strings.ToLower(fnr)+"/") {
+	// Skip this if the circuit breaker is disabled
+	if s.opts.ErrorAbortRate <= 0 {
+		return
+	}
 
-													// Add the directory to the list for later recursion
-													ac.foundDirectories = append(ac.foundDirectories, fnr)
+	// Add the outcome to the window, replacing the oldest once full
+	st.Lock()
+	defer st.Unlock()
+	if len(st.window) < errorWindow {
+		st.window = append(st.window, failed)
+	} else {
+		if st.window[st.windowPos] {
+			st.windowErrors--
+		}
+		st.window[st.windowPos] = failed
+		st.windowPos = (st.windowPos + 1) % errorWindow
+	}
+	if failed {
+		st.windowErrors++
+	}
 
-												}
+	// Abort the host if the error rate is too high
+	if r := float64(st.windowErrors) / errorWindow; len(st.window) == errorWindow && r >= s.opts.ErrorAbortRate && st.abortHost != nil {
+		log.WithFields(log.Fields{"url": url, "rate": r}).Warn("Error rate exceeded, aborting host")
+		st.abortHost()
+		st.abortHost = nil
+	}
 
-											}
+}
 
-										}
+// headerData holds the per-request variables available to header templates
+type headerData struct {
+	URL       string
+	Path      string
+	Method    string
+	Timestamp int64
+}
 
-									}
+// fetch requests the given URL without following redirects (see fetchWith)
+func (s *Scanner) fetch(ctx context.Context, st *httpStats, method string, url string) (*http.Response, []byte, error) {
+	return s.fetchWith(ctx, s.client, st, method, url)
+}
 
-								}()
+// fetchWith requests the given URL using the given client and returns an HTTP response object along with the
+// start of the body (the response body itself is already closed), handling retries gracefully
+func (s *Scanner) fetchWith(ctx context.Context, hc *http.Client, st *httpStats, method string, url string) (*http.Response, []byte, error) {
 
-								// Break the loop if there was an autocomple match
-								if fnr != "" {
-									break
-								}
+	// Create a request object, with the custom body if one was given
+	var rb io.Reader
+	if s.opts.Body != "" {
+		rb = strings.NewReader(s.opts.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, rb)
+	if err != nil {
+		return nil, nil, err
+	}
 
-							}
+	// Default user agent
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/1337.00 (KHTML, like Gecko) Chrome/1337.0.0.0 Safari/1337.00")
 
-						}
+	// Custom headers, shuffled if requested (Go writes distinct header names in sorted order, so this
+	// mostly affects the order of repeated headers)
+	headers := s.opts.Headers
+	if s.opts.Randomise {
+		headers = append([]string(nil), headers...)
+		s.rng.Shuffle(len(headers), func(i, j int) { headers[i], headers[j] = headers[j], headers[i] })
+	}
 
-						// Indicate which parts of the filename are uncertain
-						fn, fe := br.file, br.ext
-						if len(fn) >= 6 {
-							fn = fn + "?"
-						}
-						if len(fe) >= 4 {
-							fe = fe + "?"
-						}
+	// Loop through custom headers
+	for _, h := range headers {
 
-						// Colourise and output the filename, file parts, and full filename
-						if args.Output == "human" {
+		// Split the header (the alternative is to use textproto.ReadMIMEHeader(), but that's more involved)
+		hs := strings.SplitN(h, ":", 2)
+		if len(hs) != 2 {
+			return nil, nil, fmt.Errorf("invalid header: %s", h)
+		}
 
-							var fp, ff string
-							if fnr != "" {
-								fp = color.HiBlackString(fn + fe)
-								if args.FullUrl {
-									ff = color.GreenString(br.url) + color.HiGreenString(pathEscape(strings.ToLower(fnr)))
-								} else {
-									ff = color.HiGreenString(fnr)
-								}
-							} else {
-								if len(br.file) < 6 {
-									fn = color.GreenString(fn)
-								}
-								if len(br.ext) < 4 {
-									fe = color.GreenString(fe)
-								}
-								fp = strings.Replace(fn+fe, "?", color.HiBlackString("?"), -1)
-							}
-							printHuman(fmt.Sprintf("%-20s %-28s %s", br.file+br.tilde+br.ext, fp, ff))
+		// Evaluate the header value if it's a template
+		h, v := strings.Trim(hs[0], " "), strings.Trim(hs[1], " ")
+		if t, ok := s.headerTemplates[v]; ok {
+			var b strings.Builder
+			hd := headerData{URL: url, Path: req.URL.Path, Method: method, Timestamp: time.Now().Unix()}
+			if err := t.Execute(&b, hd); err != nil {
+				return nil, nil, fmt.Errorf("unable to evaluate header template for %s: %w", h, err)
+			}
+			v = b.String()
+		}
 
-						} else {
+		// Add the header (host requires handling a little differently)
+		if strings.ToLower(h) == "host" {
+			req.Host = v
+		} else {
+			req.Header.Add(h, v)
+		}
 
-							// Output JSON result if requested
-							o := resultOutput{
-								Type:      "result",
-								FullMatch: fnr != "",
-								BaseUrl:   br.url,
-								File:      br.file,
-								Tilde:     br.tilde,
-								Ext:       br.ext,
-								Partname:  fn + fe,
-								Fullname:  fnr,
-							}
-							printJSON(o)
+	}
 
-						}
+	// IIS only treats a DEBUG request as a debugging command when it says which command to run (stop-debug is the
+	// harmless one), and responds to it distinctively (unless a custom Command header was given)
+	if method == "DEBUG" && req.Header.Get("Command") == "" {
+		req.Header.Set("Command", "stop-debug")
+	}
 
-					} else if err == nil && len(br.ext) > 0 {
+	// Ask for uncompressed responses if requested (unless a custom Accept-Encoding was given)
+	if s.opts.NoCompression && req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "identity")
+	}
 
-						// This gets hit if the full match response is the same as the negative match (may need future work)
-						log.WithFields(log.Fields{"status": res.StatusCode, "statusNeg": mk.statusNeg, "filename": br.file + br.tilde + br.ext + ac.suffix}).
-							Debug("Possible hit, but status is the same as a negative match")
+	// Request loop
+	var t int
+	var rerr error
+	var res *http.Response
+	for t = 0; t < 4; t++ {
 
-					}
+		// Wait out any pause caused by rate limiting
+		st.Lock()
+		p := time.Until(st.pauseUntil)
+		st.Unlock()
+		if p > 0 {
+			select {
+			case <-time.After(p):
+			case <-ctx.Done():
+			}
+		}
 
-					// Kick off file extension discovery
-					if len(br.ext) == 0 {
-						nr := br
-						nr.ext = "."
-						enumerate(sem, wg, hc, st, ac, mk, nr)
-					}
+		// Make the request (if the scan's request budget allows), rewinding the body on retries
+		if !st.budget.take() {
+			return nil, nil, ErrMaxRequests
+		}
+		if t > 0 && req.GetBody != nil {
+			req.Body, _ = req.GetBody()
+		}
+		res, rerr = hc.Do(req)
 
+		// If the server says we're being rate limited pause all requests and retry (unless this was the last attempt)
+		if rerr == nil && (res.StatusCode == 429 || res.StatusCode == 503) && t < 3 {
+			if d, ok := retryAfter(res.Header.Get("Retry-After")); ok {
+				log.WithFields(log.Fields{"status": res.StatusCode, "url": url, "pause": d}).Warn("Rate limited, backing off")
+				st.Lock()
+				if u := time.Now().Add(d); u.After(st.pauseUntil) {
+					st.pauseUntil = u
 				}
+				st.Unlock()
+				io.Copy(io.Discard, res.Body)
+				res.Body.Close()
+				continue
+			}
+		}
 
-				// If the rabbit hole goes deeper
-				if (extMode && len(br.ext) < 4) || (!extMode && len(br.file) < 6) {
+		// Break the loop if everything went well
+		if rerr == nil {
+			break
+		}
 
-					// Build the character check URL
-					var url string
-					if extMode {
-						url = br.url + pathEscape(br.file) + br.tilde + pathEscape(br.ext) + "%3f*" + ac.suffix
-					} else {
-						url = br.url + pathEscape(br.file) + "%3f*" + br.tilde + "*" + pathEscape(br.ext) + ac.suffix
-					}
+		// Count the error (timeouts caused by the scan being cancelled don't count as timeouts)
+		var ne net.Error
+		st.Lock()
+		st.errors++
+		if errors.As(rerr, &ne) && ne.Timeout() && ctx.Err() == nil {
+			st.timeouts++
+		}
+		st.Unlock()
 
-					// Recurse if there are more characters in the name
-					res, err = fetch(hc, st, ac.method, url)
-					if err == nil && res.StatusCode != mk.statusNeg {
-						enumerate(sem, wg, hc, st, ac, mk, br)
-					}
+		// Give up if the scan has been cancelled or this was the last attempt
+		if ctx.Err() != nil || t == 3 {
+			break
+		}
 
-				}
+		// Back off and retry if there was an error
+		d := time.Duration(t*2) * time.Second
+		log.WithFields(log.Fields{"err": rerr}).Trace(fmt.Sprintf("fetch() failed, retrying in %s", d))
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+		}
 
-			}
+	}
 
-		}(sem, wg, hc, ac, mk, br, string(char))
+	// Track the outcome for the circuit breaker and the request log
+	s.recordOutcome(st, url, res == nil)
+	s.logRequest(method, url, res, rerr)
 
+	// Count the retries made, and the request as failed if it never got a response (unless the scan was cancelled)
+	st.Lock()
+	st.retries += t
+	if res == nil && ctx.Err() == nil {
+		st.failures++
 	}
+	st.Unlock()
 
-}
+	// Return the last error if there's no result
+	if res == nil {
+		return nil, nil, rerr
+	}
 
-// autocomplete returns a list of possible full filenames for a given tilde filename
-func autocomplete(ac *attackConfig, br baseRequest) []wordlistRecord {
+	// Debug
+	log.WithFields(log.Fields{"method": method, "url": url, "status": res.StatusCode}).Trace("fetch()")
 
-	// Match the filename against each wordlist entry
-	var fs = make(map[string]wordlistRecord)
-	var ch = make(chan wordlistRecord, 1024)
-	go getWordlist(ch, ac)
-	for record := range ch {
+	// Keep the start of the (decompressed) body for comparing responses, then read the rest and close it so the
+	// connection can be reused, counting the bytes as received
+	var raw byteCounter
+	br := bufio.NewReader(io.TeeReader(res.Body, &raw))
+	body, _ := io.ReadAll(io.LimitReader(decodeBody(res.Header.Get("Content-Encoding"), br), int64(s.opts.BodySample)))
+	io.Copy(io.Discard, br)
+	res.Body.Close()
 
-		// If the discovered filename and extension match the wordlist entry add the word to the list
-		if br.file == record.filename83 && br.ext[maths.Min(len(br.ext), 1):] == record.extension83 {
-			fs[record.filename+record.extension] = record
-		}
+	// Update request stats (headers are counted as they'd be written on the wire, which is close enough)
+	var tx, rx byteCounter
+	fmt.Fprintf(&tx, "%s %s HTTP/1.1\r\nHost: %s\r\n", method, req.URL.RequestURI(), req.Host)
+	req.Header.Write(&tx)
+	fmt.Fprintf(&rx, "%s %s\r\n", res.Proto, res.Status)
+	res.Header.Write(&rx)
+	st.Lock()
+	st.requests++
+	st.bytesTx += int(tx) + 2
+	st.bytesRx += int(rx) + 2 + int(raw)
+	st.Unlock()
 
-	}
+	// Return the result
+	return res, body, nil
 
-	// Convert the guess set to a slice
-	f := make([]wordlistRecord, 0, len(fs))
-	for _, v := range fs {
-		f = append(f, v)
-	}
+}
 
-	// Logging
-	if len(f) > 0 {
-		log.WithFields(log.Fields{"file": br.file, "ext": br.ext, "count": len(f)}).Info("Autocomplete found candidates")
-		log.WithFields(log.Fields{"candidates": f}).Trace("Autocomplete candidates")
+// newLimiter returns a concurrency limiter allowing up to max concurrent jobs; in adaptive mode the limit
+// starts low and is adjusted in an AIMD fashion (additive increase, multiplicative decrease)
+func newLimiter(max int, adaptive bool) *limiter {
+	l := &limiter{limit: max, max: max, adaptive: adaptive}
+	if adaptive {
+		l.limit = maths.Min(2, max)
 	}
+	l.cond = sync.NewCond(l)
+	return l
+}
 
-	// Return the slice
-	return f
+// acquire waits for a free slot
+func (l *limiter) acquire() {
+	l.Lock()
+	for l.active >= l.limit {
+		l.cond.Wait()
+	}
+	l.active++
+	l.Unlock()
+}
 
+// tryAcquire takes a free slot if there is one, without waiting
+func (l *limiter) tryAcquire() bool {
+	l.Lock()
+	defer l.Unlock()
+	if l.active >= l.limit {
+		return false
+	}
+	l.active++
+	return true
 }
 
-// autodechecksum tries to reconstitute Windows checksummed filenames
-func autodechecksum(ac *attackConfig, br baseRequest) []wordlistRecord {
+// release frees a slot and, in adaptive mode, adjusts the limit based on whether errors or retries have
+// been seen since the last job finished
+func (l *limiter) release(st *httpStats) {
 
-	// Get the 1-2 prefix letters and potential checksum
-	l := 2 - (6 - len(br.file))
-	prefix, checksum := br.file[:l], br.file[l:]
-	log.WithFields(log.Fields{"file": br.file, "prefix": prefix, "checksum": checksum}).Info("Possible checksummed alias")
+	// Get the current error count
+	st.Lock()
+	e := st.errors + st.retries
+	st.Unlock()
 
-	// Match the checksum and prefix against each wordlist entry
-	var fs = make(map[string]wordlistRecord)
-	var ch = make(chan wordlistRecord, 1024)
-	go getWordlist(ch, ac)
-	for record := range ch {
-
-		// If the potential checksum matches a wordlist checksum and the filename prefix and extension match
-		for i := 0; i < len(record.checksums); i += 4 {
-			c := record.checksums[i : i+4]
-			if c == checksum && strings.HasPrefix(strings.ToUpper(record.filename), prefix) && strings.HasPrefix(strings.ToUpper(record.extension), br.ext) {
-				fs[record.filename+record.extension] = record
-			}
+	// Free the slot
+	l.Lock()
+	defer l.Unlock()
+	l.active--
+
+	// Adjust the limit, halving on errors and creeping up after a full limit's worth of healthy jobs
+	if l.adaptive {
+		if e > l.errors {
+			l.limit = maths.Max(1, l.limit/2)
+			l.healthy = 0
+			log.WithFields(log.Fields{"concurrency": l.limit}).Debug("Errors seen, reducing concurrency")
+		} else if l.healthy++; l.healthy >= l.limit && l.limit < l.max {
+			l.limit++
+			l.healthy = 0
+			log.WithFields(log.Fields{"concurrency": l.limit}).Trace("Server healthy, increasing concurrency")
 		}
-
-	}
-
-	// Convert the guess set to a slice
-	f := make([]wordlistRecord, 0, len(fs))
-	for _, v := range fs {
-		f = append(f, v)
-	}
-
-	// Logging
-	if len(f) > 1 {
-		log.WithFields(log.Fields{"file": br.file, "ext": br.ext, "count": len(f)}).Info("Dechecksum found candidates")
-		log.WithFields(log.Fields{"candidates": f}).Trace("Dechecksum candidates")
+		l.errors = e
 	}
 
-	// Return the slice
-	return f
+	// Wake up anything waiting for a slot
+	l.cond.Broadcast()
 
 }
 
-// getStatuses fetches non-existent URLs and returns a list of response statuses
-func getStatuses(c wordlistRecord, br baseRequest, hc *http.Client, st *httpStats) map[int]struct{} {
+// enumerate builds and fetches candidate short name URLs making use of recursion
+func (s *Scanner) enumerate(ctx context.Context, sem *limiter, wg *sync.WaitGroup, st *httpStats, ac *attackConfig, mk markers, br baseRequest) {
 
-	// Returned cached statuses if they exist
-	if len(statusCache[c.extension]) > 0 {
-		return statusCache[c.extension]
-	}
+	// Extension enumeration mode (unless the extension was found first, in which case only the filename is enumerated)
+	extMode := len(br.ext) > 0 && !br.extKnown
 
-	// Set loop count based on stability
-	l := 2
-	if args.Stabilise {
-		l = 12
+	// Select the character map to use
+	var chars string
+	if extMode {
+		chars = ac.extChars[br.tilde]
+	} else {
+		chars = ac.fileChars[br.tilde]
+	}
+	if br.chars != "" {
+		chars, br.chars = br.chars, ""
+	}
+	if s.opts.HitOrder {
+		chars = ac.byHits(hitKey(extMode, br.tilde), chars)
 	}
 
-	// Loop
-	statuses := make(map[int]struct{}, l)
-	for i := 0; i < l; i++ {
+	// Loop through characters
+	for _, char := range chars {
 
-		// Generate a random filename with the autocomplete candidate file extension
-		path := randPath(rand.Intn(4)+8, 0, alphanum) + c.extension
+		// Increment the waitgroup
+		wg.Add(1)
 
-		// Fetch the URL
-		if res, err := fetch(hc, st, "GET", br.url+path); err == nil {
-			statuses[res.StatusCode] = struct{}{}
-		}
+		// Check goroutine
+		go func(sem *limiter, wg *sync.WaitGroup, ac *attackConfig, mk markers, br baseRequest, char string) {
 
-	}
+			// Waitgroup and semaphore handling
+			sem.acquire()
+			defer func(sem *limiter, wg *sync.WaitGroup) {
+				sem.release(st)
+				wg.Done()
+			}(sem, wg)
 
-	// Logging
-	log.WithFields(log.Fields{"extension": c.extension, "statuses": statuses}).Info("Got non-existent file statuses")
+			// Bail if the scan has been cancelled
+			if ctx.Err() != nil {
+				return
+			}
 
-	// Cache and return the statuses
-	statusCache[c.extension] = statuses
-	return statuses
+			// Workaround for an IIS bug which makes the two characters following a percent sign
+			// in the 0-F range always return a match (so we just skip them)
+			if char == "%" {
+				var x, y int
+				if extMode {
+					x, y = len(br.ext), s.opts.MaxExtLen-2
+				} else {
+					x, y = len(br.file), s.opts.MaxNameLen-2
+				}
+				for i := 0; i < 2 && x < y; i++ {
+					char += "?"
+				}
+			}
 
-}
+			// Add the next character and build the initial check URL
+			var url string
+			if extMode {
+				br.ext += char
+				url = br.url + s.filePart(ac, br.file) + br.tilde + pathEscape(br.ext) + ac.wc.star + ac.suffix
+			} else {
+				br.file += char
+				url = br.url + pathEscape(br.file) + ac.wc.star + br.tildeAny(ac) + s.extPart(ac, br.ext) + ac.suffix
+			}
 
-// getDistances calculates response distances for the given URL
-func getDistances(c wordlistRecord, br baseRequest, hc *http.Client, st *httpStats, ac *attackConfig) map[int]distances {
+			// Check whether this looks like a hit
+			res, _, err := s.fetch(ctx, st, ac.method, url)
+			if err == nil && res.StatusCode == mk.statusPos {
 
-	// Lock the mutex
-	ac.distanceMutex.Lock()
-	defer ac.distanceMutex.Unlock()
+				// Note which character hit so later branches can try the likeliest ones first
+				if s.opts.HitOrder {
+					ac.addHit(hitKey(extMode, br.tilde), []rune(char)[0])
+				}
 
-	// Return distances if cached
-	if len(distanceCache[c.extension]) > 0 {
-		return distanceCache[c.extension]
-	}
+				// Check whether this is the full file part (this probe is kept as evidence of the hit)
+				probeUrl := br.url + s.filePart(ac, br.file) + br.tildeAny(ac) + s.extPart(ac, br.ext) + ac.suffix
+				res, _, err := s.fetch(ctx, st, ac.method, probeUrl)
+				if err == nil && res.StatusCode == mk.statusPos {
 
-	// Status
-	log.WithFields(log.Fields{"url": br.url, "extension": c.extension}).Info("Sampling responses for Levenshtein distance calculation")
+					// Check whether there's an extension (some servers return a different status (e.g. 500 Internal Server Error)
+					// when the full name matches, so this final check is loosened to a negative match so we don't miss anything)
+					res, _, err := s.fetch(ctx, st, ac.method, br.url+s.filePart(ac, br.file)+br.tilde+s.extPart(ac, br.ext)+ac.suffix)
+					if err == nil && res.StatusCode != mk.statusNeg {
 
-	// Set loop count based on stability
-	l := 4
-	if args.Stabilise {
-		l = 24
-	}
+						// Resolve and output the name
+						s.report(ctx, sem, st, ac, br, probeUrl)
 
-	// Sample some random URLs and calculate distances between the first 1k of the response body
-	bodies := make(map[int][]string, l)
-	highdist := make(map[int]float32, l)
-	dists := make(map[int]distances)
-	var path string
-	for i := 0; i < l; i++ {
+					} else if err == nil && len(br.ext) > 0 {
+
+						// This gets hit if the full match response is the same as the negative match (may need future work)
+						log.WithFields(log.Fields{"status": res.StatusCode, "statusNeg": mk.statusNeg, "filename": br.file + br.tilde + br.ext + ac.suffix}).
+							Debug("Possible hit, but status is the same as a negative match")
 
-		// Generate a random path ending in the candidate file extension
-		path = randPath(rand.Intn(4)+8, 0, alphanum) + c.extension
+					}
+
+					// Kick off file extension discovery (unless disabled, or the name has no extension), trying just the final character
+					// of each listed extension if a list was given
+					if len(br.ext) == 0 && !s.opts.NoExt && !br.extKnown && s.hasExtension(ctx, st, ac, mk, br) {
+						if len(s.extensions) > 0 {
+							for _, e := range s.extensions {
+								nr := br
+								nr.ext, nr.chars = "."+e[:len(e)-1], e[len(e)-1:]
+								s.enumerate(ctx, sem, wg, st, ac, mk, nr)
+							}
+						} else {
+							nr := br
+							nr.ext = "."
+							s.enumerate(ctx, sem, wg, st, ac, mk, nr)
+						}
+					}
+
+				}
 
-		// Fetch the URL
-		if res, err := fetch(hc, st, "GET", br.url+path); err == nil {
+				// If the rabbit hole goes deeper
+				if (extMode && len(br.ext) <= s.opts.MaxExtLen) || (!extMode && len(br.file) < s.opts.MaxNameLen) {
 
-			// Read body (not checking for EOF, because an empty body still needs a sample)
-			b := make([]byte, 1024)
-			res.Body.Read(b)
-			body := string(b)
-			for j := 0; j < len(bodies[res.StatusCode])-1; j++ {
+					// Build the character check URL
+					var url string
+					if extMode {
+						url = br.url + s.filePart(ac, br.file) + br.tilde + pathEscape(br.ext) + ac.wc.qmark + ac.wc.star + ac.suffix
+					} else {
+						url = br.url + pathEscape(br.file) + ac.wc.qmark + ac.wc.star + br.tildeAny(ac) + s.extPart(ac, br.ext) + ac.suffix
+					}
 
-				// Calculate Levenshtein distance
-				ld := levenshtein.Distance(bodies[res.StatusCode][j], body)
+					// At patience level 2, re-probe a few times before pruning the branch in case of a flaky server
+					attempts := 1
+					if s.opts.Patience >= 2 {
+						attempts = 3
+					}
 
-				// Turn the distance into a percentage
-				lp := float32(ld) / float32(maths.Max(len(bodies[res.StatusCode][j]), len(body)))
+					// Recurse if there are more characters in the name
+					for i := 0; i < attempts; i++ {
+						res, _, err = s.fetch(ctx, st, ac.method, url)
+						if err == nil && res.StatusCode != mk.statusNeg {
+							s.enumerate(ctx, sem, wg, st, ac, mk, br)
+							break
+						}
+						if i+1 < attempts {
+							log.WithFields(log.Fields{"url": url, "attempt": i + 1}).Debug("Continuation check negative, re-probing")
+						}
+					}
 
-				// Store the highest distance and corresponding response for later comparison
-				if dists[res.StatusCode] == (distances{}) || lp > highdist[res.StatusCode] {
-					dists[res.StatusCode] = distances{lp, body}
-					highdist[res.StatusCode] = lp
 				}
 
 			}
 
-			// Save the response sample
-			bodies[res.StatusCode] = append(bodies[res.StatusCode], body)
+		}(sem, wg, ac, mk, br, string(char))
 
-		}
 	}
 
-	// Log calculated distances
-	for s, d := range dists {
-		log.WithFields(log.Fields{"extension": c.extension, "status": s, "distance": d.distance}).Info("Calculated Levenshtein distance")
-	}
+}
 
-	// Cache and return
-	distanceCache[c.extension] = dists
-	return dists
+// knownFile reports whether a full filename has already been found
+func (ac *attackConfig) knownFile(path string) bool {
+	ac.foundMutex.Lock()
+	defer ac.foundMutex.Unlock()
+	_, ok := ac.foundFiles[path]
+	return ok
+}
 
+// claimFile records a full filename as found, returning false if it already had been
+func (ac *attackConfig) claimFile(path string) bool {
+	ac.foundMutex.Lock()
+	defer ac.foundMutex.Unlock()
+	if _, ok := ac.foundFiles[path]; ok {
+		return false
+	}
+	ac.foundFiles[path] = struct{}{}
+	return true
 }
 
-// getWordlist returns wordlist entries
-func getWordlist(ch chan wordlistRecord, ac *attackConfig) {
+// addDirectory records a directory for later recursion
+func (ac *attackConfig) addDirectory(name string) {
+	ac.foundMutex.Lock()
+	defer ac.foundMutex.Unlock()
+	ac.foundDirectories = append(ac.foundDirectories, name)
+}
 
-	// Lock the wordlist
-	ac.wordlist.Lock()
+// directories returns a snapshot of the directories found so far
+func (ac *attackConfig) directories() []string {
+	ac.foundMutex.Lock()
+	defer ac.foundMutex.Unlock()
+	return append([]string(nil), ac.foundDirectories...)
+}
 
-	// Return each word
-	for _, record := range ac.wordlist.wordlist {
-		ch <- record
+// hitKey returns the key that character hits are counted under, kept separately for filenames and extensions at
+// each tilde level since their character distributions differ
+func hitKey(ext bool, tilde string) string {
+	if ext {
+		return "ext" + tilde
 	}
+	return "file" + tilde
+}
 
-	// Unlock the wordlist and close the channel
-	ac.wordlist.Unlock()
-	close(ch)
+// addHit counts a hit for a character
+func (ac *attackConfig) addHit(key string, char rune) {
+
+	ac.hitMutex.Lock()
+	defer ac.hitMutex.Unlock()
+	if ac.hits == nil {
+		ac.hits = make(map[string]map[rune]int)
+	}
+	if ac.hits[key] == nil {
+		ac.hits[key] = make(map[rune]int)
+	}
+	ac.hits[key][char]++
 
 }
 
-// randPath returns a random path built with the provided characters
-func randPath(l int, d int, chars string) string {
-	c := len(chars)
-	b := make([]byte, l)
-	for i := range b {
-		b[i] = chars[rand.Intn(c)]
+// byHits returns the characters ordered by how many hits they've produced so far, most first, keeping the original
+// order for characters with the same count
+func (ac *attackConfig) byHits(key string, chars string) string {
+
+	ac.hitMutex.Lock()
+	defer ac.hitMutex.Unlock()
+	h := ac.hits[key]
+	if len(h) == 0 {
+		return chars
 	}
-	for i := 0; i < d; i++ {
-		b[rand.Intn(l)] = '.'
+	r := []rune(chars)
+	sort.SliceStable(r, func(i, j int) bool { return h[r[i]] > h[r[j]] })
+	return string(r)
+
+}
+
+// report resolves, classifies and outputs a short name found on the server, autocompleting it if enabled
+func (s *Scanner) report(ctx context.Context, sem *limiter, st *httpStats, ac *attackConfig, br baseRequest, probeUrl string) {
+
+	// If autocomplete is enabled
+	var fnr, method string
+	if ac.autocomplete != "none" {
+
+		// Look up candidate filenames in the wordlist
+		fnc := wordlistCandidates(ac, br)
+
+		// Fall back to candidates synthesised from the stem and common extensions if requested
+		if s.opts.ExpandExt && len(br.ext) > 0 {
+			fnc = append(fnc, s.expandExtensions(br)...)
+		}
+
+		// Cap the number of candidates tried, keeping the most likely (each source is already ordered)
+		if s.opts.MaxCandidates > 0 && len(fnc) > s.opts.MaxCandidates {
+			log.WithFields(log.Fields{"file": br.file, "ext": br.ext, "candidates": len(fnc), "max": s.opts.MaxCandidates}).Debug("Limiting autocomplete candidates")
+			fnc = fnc[:s.opts.MaxCandidates]
+		}
+
+		// Choose the request method
+		if ac.autocomplete == "method" {
+			method = "_"
+		} else {
+			method = "GET"
+		}
+
+		// Loop through each filename candidate
+		for _, c := range fnc {
+
+			// Encapsulated to simplify returning early
+			func() {
+
+				// Set the path
+				path := pathEscape(c.filename + c.extension)
+
+				// Skip this filename if it collides with a known discovery
+				if ac.knownFile(path) {
+					return
+				}
+
+				// Make a request to the candidate URL
+				res, body, err := s.fetchWith(ctx, s.followClient, st, method, br.url+path)
+
+				// Skip this check if there was an error
+				if err != nil {
+					s.logRequestError(log.Fields{"err": err, "method": method, "url": br.url + path}, "Existence check error")
+					return
+				}
+
+				// Branch based on autocomplete mode
+				if ac.autocomplete == "method" {
+
+					// When an invalid HTTP method is sent, a "405 Method Not Allowed" response from IIS indicates that a file
+					// exists; this check is less noisy (and often more reliable) than methods such as status or distance checks
+					if res.StatusCode == 405 {
+						fnr = path
+					}
+
+				} else if ac.autocomplete == "status" {
+
+					// Check the response doesn't appear in this candidate's negative status set
+					ss := s.getStatuses(ctx, sem, c, br, st, ac)
+
+					if _, e := ss[res.StatusCode]; !e {
+						fnr = path
+					}
+
+				} else if ac.autocomplete == "distance" {
+
+					// Get distances for this candidate
+					dists := s.getDistances(ctx, sem, c, br, st, ac)
+
+					// If the status code wasn't seen during sampling
+					if dists[res.StatusCode] == (distances{}) {
+						log.WithFields(log.Fields{"url": br.url + path, "status": res.StatusCode}).Info("Autocomplete got a status code hit")
+						fnr = path
+					} else {
+
+						// If the distance from the sample response is more than 10% beyond the usual distance
+						lp, d := dists[res.StatusCode].compare(body)
+						if d > 0.1 {
+							log.WithFields(log.Fields{"url": br.url + path, "distance": lp, "delta": d}).Info("Autocomplete got a distance hit")
+							fnr = path
+						}
+
+					}
+
+				} else {
+
+					// Bail if the autocomplete mode is unrecognised (this should never happen)
+					log.Fatal("What are you doing here?")
+
+				}
+
+				// If a full filename was found, claim it unless another short name got there first while the
+				// request was in flight (in which case this one moves on to the next candidate)
+				if fnr != "" && !ac.claimFile(fnr) {
+					fnr = ""
+				}
+
+			}()
+
+			// Break the loop if there was an autocomple match
+			if fnr != "" {
+				break
+			}
+
+		}
+
 	}
-	return pathEscape(string(b))
+
+	// Classify the result as a file or directory using the full name if known, or the short name if not (this takes a
+	// request, so it's only done when something uses the answer: recursion, the --only-dirs/--only-files filters, or
+	// suggesting recursion into unresolved directories; anything else is reported as a file)
+	name := fnr
+	if name == "" {
+		name = pathEscape(br.file) + br.tilde + pathEscape(br.ext)
+	}
+	isDir := false
+	if s.opts.OnlyDirs || s.opts.OnlyFiles || (!s.opts.NoRecurse && (fnr != "" || s.opts.RecurseShort || s.opts.Suggest)) {
+		isDir = s.isDirectory(ctx, st, br.url, name)
+	}
+
+	// Add directories to the list for later recursion (unresolved short names only if requested)
+	if isDir && !s.opts.NoRecurse && (fnr != "" || s.opts.RecurseShort) {
+		ac.addDirectory(name)
+	}
+
+	// Tally the result for the per-URL summary
+	ac.resultMutex.Lock()
+	if isDir {
+		ac.dirCount++
+	} else if fnr != "" {
+		ac.fileCount++
+	} else {
+		ac.partialCount++
+	}
+
+	// Track which tilde levels share this stem to determine the collision count
+	if ac.stems[br.file+br.ext] == nil {
+		ac.stems[br.file+br.ext] = make(map[string]struct{})
+	}
+	ac.stems[br.file+br.ext][br.tilde] = struct{}{}
+	ac.resultMutex.Unlock()
+
+	// Indicate which parts of the filename are uncertain
+	fn, fe := br.file, br.ext
+	if len(fn) >= s.opts.MaxNameLen {
+		fn = fn + "?"
+	}
+	if len(fe) > s.opts.MaxExtLen {
+		fe = fe + "?"
+	}
+
+	// Confirm the short name by requesting it directly if requested
+	var confirmed *bool
+	if s.opts.ConfirmShort {
+		c := s.confirmShort(ctx, sem, st, ac, br)
+		confirmed = &c
+	}
+
+	// Probe the resolved file for its response metadata if requested
+	var probe *probeResult
+	if s.opts.ProbeConfirmed && fnr != "" {
+		probe = s.probeFile(ctx, st, br.url+pathEscape(fnr))
+	}
+
+	// Keep the probe which matched the short name as evidence if requested (names given with --shortnames have none)
+	evidence := ""
+	if (s.opts.Evidence || s.opts.Verbosity > 0) && probeUrl != "" {
+		evidence = ac.method + " " + probeUrl
+	}
+
+	// Colourise and output the filename, file parts, and full filename (unless filtered out)
+	if (s.opts.OnlyDirs && !isDir) || (s.opts.OnlyFiles && isDir) {
+		log.WithFields(log.Fields{"file": br.file, "tilde": br.tilde, "ext": br.ext, "directory": isDir}).Debug("Result filtered from output")
+	} else if s.opts.Output == "human" && !s.opts.Table {
+
+		var fp, ff string
+		if fnr != "" {
+			fp = color.HiBlackString(fn + fe)
+			if s.opts.FullUrl {
+				ff = color.GreenString(br.url) + color.HiGreenString(pathEscape(strings.ToLower(fnr)))
+			} else {
+				ff = color.HiGreenString(fnr)
+			}
+		} else {
+			if len(br.file) < s.opts.MaxNameLen {
+				fn = color.GreenString(fn)
+			}
+			if len(br.ext) <= s.opts.MaxExtLen {
+				fe = color.GreenString(fe)
+			}
+			fp = strings.Replace(fn+fe, "?", color.HiBlackString("?"), -1)
+		}
+		sn := br.file + br.tilde + br.ext
+		if isDir {
+			sn += "/"
+		}
+		if confirmed != nil && *confirmed {
+			ff = strings.TrimSpace(ff + " " + color.HiGreenString("[confirmed]"))
+		} else if confirmed != nil {
+			ff = strings.TrimSpace(ff + " " + color.HiBlackString("[unconfirmed]"))
+		}
+		if probe != nil {
+			ff += " " + color.HiBlackString("[%s]", probe)
+		}
+		if evidence != "" {
+			ff = strings.TrimSpace(ff + " " + color.HiBlackString("[%s]", evidence))
+		}
+		s.printHuman(fmt.Sprintf("%-20s %-28s %s", sn, fp, ff))
+
+	}
+
+	// Buffer the result until this URL is finished so the collision count is complete
+	if !(s.opts.OnlyDirs && !isDir) && !(s.opts.OnlyFiles && isDir) {
+		t := "file"
+		if isDir {
+			t = "directory"
+		}
+		o := resultOutput{
+			Type:      t,
+			Version:   version,
+			FullMatch: fnr != "",
+			BaseUrl:   br.url,
+			ParentUrl: br.parent,
+			ShortName: br.file + br.tilde + br.ext,
+			File:      br.file,
+			Tilde:     br.tilde,
+			Ext:       br.ext,
+			Partname:  fn + fe,
+			Fullname:  fnr,
+			Confirmed: confirmed,
+		}
+		if fnr == "" {
+			o.FuzzPattern = fn + fe
+		}
+		if probe != nil {
+			o.Status, o.ContentLength, o.ContentType = probe.status, probe.length, probe.contentType
+		}
+		if evidence != "" {
+			o.ProbeUrl, o.ProbeMethod = probeUrl, ac.method
+		}
+		ac.resultMutex.Lock()
+		ac.results = append(ac.results, o)
+		o.CollisionCount = len(ac.stems[o.File+o.Ext])
+		ac.resultMutex.Unlock()
+		if s.onResult != nil {
+			s.onResult(o)
+		}
+
+	}
+
 }
 
-// printHuman prints human readable output if enabled
-func printHuman(s ...any) {
-	if args.Output == "human" {
-		fmt.Println(s...)
+// hasExtension checks whether a short name has any extension with a single probe, which saves enumerating every
+// possible first character of the extension for names which don't have one
+func (s *Scanner) hasExtension(ctx context.Context, st *httpStats, ac *attackConfig, mk markers, br baseRequest) bool {
+
+	// At patience level 2, re-probe a few times before giving up in case of a flaky server
+	attempts := 1
+	if s.opts.Patience >= 2 {
+		attempts = 3
+	}
+
+	// Check for a dot followed by at least one character
+	url := br.url + s.filePart(ac, br.file) + br.tilde + "." + ac.wc.qmark + ac.wc.star + ac.suffix
+	for i := 0; i < attempts; i++ {
+		res, _, err := s.fetch(ctx, st, ac.method, url)
+		if err == nil && res.StatusCode != mk.statusNeg {
+			return true
+		}
 	}
+	log.WithFields(log.Fields{"file": br.file, "tilde": br.tilde}).Debug("No extension found, skipping extension discovery")
+	return false
+
 }
 
-// printJSON prints JSON formatted output if enabled
-func printJSON(o any) {
-	if args.Output == "json" {
-		j, _ := json.Marshal(o)
-		fmt.Println(string(j))
+// filePart escapes the filename part of a short name for a probe, following it with a wildcard if it's as long as
+// enumeration goes but shorter than an 8.3 filename can be (so the rest of the real name matches whatever it is)
+func (s *Scanner) filePart(ac *attackConfig, file string) string {
+	if len(file) >= s.opts.MaxNameLen && s.opts.MaxNameLen < 6 {
+		return pathEscape(file) + ac.wc.star
+	}
+	return pathEscape(file)
+}
+
+// extPart escapes the extension part of a short name (including its dot) for a probe, in the same way as filePart
+func (s *Scanner) extPart(ac *attackConfig, ext string) string {
+	if len(ext) > s.opts.MaxExtLen && s.opts.MaxExtLen < 3 {
+		return pathEscape(ext) + ac.wc.star
+	}
+	return pathEscape(ext)
+}
+
+// tildeAny returns the tilde part of a filename check followed by a wildcard, which matches any extension (or the
+// known one when it's followed by it) unless the name is known to be extensionless, in which case a single
+// character wildcard is used so the check stays a wildcard request without matching names with an extension
+func (br baseRequest) tildeAny(ac *attackConfig) string {
+	if br.extKnown && br.ext == "" {
+		return br.tilde + ac.wc.qmark
+	}
+	return br.tilde + ac.wc.star
+}
+
+// enumerateTilde starts enumeration of the names at a tilde level, either filename first (the extension being found
+// once each filename is) or by finding the extensions in use first and then the filenames for each one
+func (s *Scanner) enumerateTilde(ctx context.Context, sem *limiter, wg *sync.WaitGroup, st *httpStats, ac *attackConfig, mk markers, br baseRequest) {
+
+	// Filename first
+	if !s.opts.ExtFirst {
+		s.enumerate(ctx, sem, wg, st, ac, mk, br)
+		return
+	}
+
+	// Extensions first, then filenames with each extension (and with none)
+	exts := s.findExtensions(ctx, sem, st, ac, mk, br)
+	log.WithFields(log.Fields{"url": br.url, "tilde": br.tilde, "extensions": exts}).Info("Found extensions")
+	for _, e := range append(exts, "") {
+		nr := br
+		nr.ext, nr.extKnown = e, true
+		s.enumerate(ctx, sem, wg, st, ac, mk, nr)
+	}
+
+}
+
+// findExtensions enumerates the extensions of the names at a tilde level, whatever their filename, returning them
+// in order (with their leading dot)
+func (s *Scanner) findExtensions(ctx context.Context, sem *limiter, st *httpStats, ac *attackConfig, mk markers, br baseRequest) []string {
+
+	var exts []string
+	var mutex sync.Mutex
+	wg := new(sync.WaitGroup)
+	base := br.url + ac.wc.star + br.tilde
+
+	// Try each character after the extension found so far
+	var try func(ext string)
+	try = func(ext string) {
+		chars := ac.extChars[br.tilde]
+		if s.opts.HitOrder {
+			chars = ac.byHits(hitKey(true, br.tilde), chars)
+		}
+		for _, char := range chars {
+
+			// Skip the percent sign, which IIS matches unreliably (see enumerate)
+			if char == '%' {
+				continue
+			}
+
+			wg.Add(1)
+			go func(e string) {
+
+				sem.acquire()
+				defer func() {
+					sem.release(st)
+					wg.Done()
+				}()
+				if ctx.Err() != nil {
+					return
+				}
+
+				// Check whether any name has an extension starting with this
+				res, _, err := s.fetch(ctx, st, ac.method, base+pathEscape(e)+ac.wc.star+ac.suffix)
+				if err != nil || res.StatusCode != mk.statusPos {
+					return
+				}
+				if s.opts.HitOrder {
+					ac.addHit(hitKey(true, br.tilde), []rune(e)[len([]rune(e))-1])
+				}
+
+				// Note it if it's a complete extension
+				if res, _, err := s.fetch(ctx, st, ac.method, base+s.extPart(ac, e)+ac.suffix); err == nil && res.StatusCode != mk.statusNeg {
+					mutex.Lock()
+					exts = append(exts, e)
+					mutex.Unlock()
+				}
+
+				// Carry on if there could be more characters
+				if len(e) <= s.opts.MaxExtLen {
+					if res, _, err := s.fetch(ctx, st, ac.method, base+pathEscape(e)+ac.wc.qmark+ac.wc.star+ac.suffix); err == nil && res.StatusCode != mk.statusNeg {
+						try(e)
+					}
+				}
+
+			}(ext + string(char))
+
+		}
+	}
+	try(".")
+	wg.Wait()
+
+	sort.Strings(exts)
+	return exts
+
+}
+
+// confirmShort checks whether a short name resolves when requested directly, by comparing its response with the
+// responses sampled for non-existent files with the same extension; a ~0 alias of the same stem (which can't exist,
+// as numbering starts at ~1) is checked the same way as a control, in case the server treats any ~N name specially
+func (s *Scanner) confirmShort(ctx context.Context, sem *limiter, st *httpStats, ac *attackConfig, br baseRequest) bool {
+
+	// Request the short name and the control (following redirects, as the samples do)
+	res, body, err := s.fetchWith(ctx, s.followClient, st, "GET", br.url+pathEscape(br.file+br.tilde+br.ext))
+	if err != nil {
+		return false
+	}
+	ctl, cbody, err := s.fetchWith(ctx, s.followClient, st, "GET", br.url+pathEscape(br.file+"~0"+br.ext))
+	if err != nil {
+		return false
+	}
+
+	// A response stands out if its status wasn't seen when sampling, or its body is more than 10% further from the
+	// sample than usual (which catches soft 404s)
+	dists := s.getDistances(ctx, sem, wordlistRecord{extension: br.ext}, br, st, ac)
+	hit := func(status int, b []byte) bool {
+		ds, seen := dists[status]
+		if !seen {
+			return true
+		}
+		_, d := ds.compare(b)
+		return d > 0.1
+	}
+	found, control := hit(res.StatusCode, body), hit(ctl.StatusCode, cbody)
+
+	// Logging
+	log.WithFields(log.Fields{"url": br.url, "short": br.file + br.tilde + br.ext, "status": res.StatusCode, "found": found, "control": ctl.StatusCode, "controlFound": control}).Debug("Short name confirmation")
+
+	return found && !control
+
+}
+
+// compare returns the Levenshtein distance (as a fraction of the longer body) between a response body and the sample
+// response, along with how much further that is than the sampled responses were from each other
+func (d distances) compare(body []byte) (float32, float32) {
+	b := string(body)
+	lp := float32(levenshtein.Distance(d.body, b)) / float32(maths.Max(len(d.body), len(b)))
+	return lp, lp - d.distance
+}
+
+// probeFile requests a resolved file (following redirects as configured) and returns its final status,
+// Content-Length (if known) and Content-Type, or nil if the request failed
+func (s *Scanner) probeFile(ctx context.Context, st *httpStats, url string) *probeResult {
+
+	// Request the file
+	res, _, err := s.fetchWith(ctx, s.followClient, st, "GET", url)
+	if err != nil {
+		s.logRequestError(log.Fields{"err": err, "url": url}, "Probe error")
+		return nil
+	}
+
+	// Record the response metadata, leaving the length unset if the server didn't send one
+	p := &probeResult{status: res.StatusCode, contentType: res.Header.Get("Content-Type")}
+	if res.ContentLength >= 0 {
+		l := res.ContentLength
+		p.length = &l
+	}
+	return p
+
+}
+
+// String formats the probe result for human output, e.g. "200 1534 text/html"
+func (p *probeResult) String() string {
+
+	parts := []string{strconv.Itoa(p.status)}
+	if p.length != nil {
+		parts = append(parts, strconv.FormatInt(*p.length, 10))
+	}
+	if p.contentType != "" {
+		parts = append(parts, p.contentType)
+	}
+	return strings.Join(parts, " ")
+
+}
+
+// isDirectory checks whether the given name under the base URL is a directory by requesting it without a
+// trailing slash and checking whether the server redirects to the slashed version
+func (s *Scanner) isDirectory(ctx context.Context, st *httpStats, url string, name string) bool {
+
+	// Make a HEAD request to the name
+	res, _, err := s.fetch(ctx, st, "HEAD", url+name)
+	if err != nil {
+		s.logRequestError(log.Fields{"err": err, "method": "HEAD", "url": url + name}, "Directory check error")
+		return false
+	}
+
+	// Check whether this looks like a directory redirect
+	return isDirectoryRedirect(url+name, res.Header.Get("Location"), name)
+
+}
+
+// isDirectoryRedirect checks whether a Location header (relative, root-relative, or absolute) points
+// to the given name with a trailing slash, which is how IIS redirects requests for a bare directory
+func isDirectoryRedirect(url string, location string, name string) bool {
+
+	// Parse the request URL and the redirect location
+	ru, err := nurl.Parse(url)
+	if err != nil || location == "" {
+		return false
+	}
+	lu, err := nurl.Parse(location)
+	if err != nil {
+		return false
+	}
+
+	// Resolve the location against the request URL and check it's a directory
+	p := ru.ResolveReference(lu).Path
+	if !strings.HasSuffix(p, "/") {
+		return false
+	}
+
+	// Compare the final path segment with the (unescaped) name
+	p = strings.TrimSuffix(p, "/")
+	n, err := nurl.PathUnescape(name)
+	if err != nil {
+		n = name
 	}
+	return strings.EqualFold(p[strings.LastIndex(p, "/")+1:], n)
+
+}
+
+// autocomplete returns a list of possible full filenames for a given tilde filename
+func autocomplete(ac *attackConfig, br baseRequest) []wordlistRecord {
+
+	// Match the filename against wordlist entries with the same 8.3 stem
+	var fs = make(map[string]wordlistRecord)
+	for _, record := range ac.wordlist.byStem[br.file] {
+
+		// If the discovered extension matches the wordlist entry add the word to the list
+		if br.ext[maths.Min(len(br.ext), 1):] == record.extension83 {
+			fs[record.filename+record.extension] = record
+		}
+
+	}
+
+	// Convert the guess set to a slice, most likely names first
+	f := make([]wordlistRecord, 0, len(fs))
+	for _, v := range fs {
+		f = append(f, v)
+	}
+	sortCandidates(f)
+
+	// Logging
+	if len(f) > 0 {
+		log.WithFields(log.Fields{"file": br.file, "ext": br.ext, "count": len(f)}).Info("Autocomplete found candidates")
+		log.WithFields(log.Fields{"candidates": f}).Trace("Autocomplete candidates")
+	}
+
+	// Return the slice
+	return f
+
+}
+
+// wordlistCandidates returns the wordlist entries which could be the full name of a short name, dechecksumming it
+// first if it looks like a checksummed alias (e.g. A5FAB~1.HTM) and a rainbow table was provided
+func wordlistCandidates(ac *attackConfig, br baseRequest) []wordlistRecord {
+	var fnc []wordlistRecord
+	if ac.wordlist.isRainbow && checksumRegex.MatchString(br.file) {
+		fnc = autodechecksum(ac, br)
+	}
+	return append(fnc, autocomplete(ac, br)...)
+}
+
+// sortCandidates orders candidates by descending wordlist priority so that common names are tried first, then
+// alphabetically so that the same scan always tries (and resolves) candidates in the same order
+func sortCandidates(f []wordlistRecord) {
+	sort.Slice(f, func(i, j int) bool {
+		if f[i].priority != f[j].priority {
+			return f[i].priority > f[j].priority
+		}
+		return f[i].filename+f[i].extension < f[j].filename+f[j].extension
+	})
+}
+
+// expandExtensions synthesises candidate filenames by combining the discovered stem with a list of common
+// extensions, which catches files (such as backups) that no static wordlist is likely to contain
+func (s *Scanner) expandExtensions(br baseRequest) []wordlistRecord {
+
+	// Build a candidate for each extension whose 8.3 form matches the discovered extension
+	var f []wordlistRecord
+	for _, e := range strings.Split(s.opts.ExpandExtList, ",") {
+		e = strings.TrimPrefix(strings.TrimSpace(e), ".")
+		if e == "" {
+			continue
+		}
+		_, f83, e83 := shortutil.Gen8dot3(br.file, e)
+		if f83 == br.file && e83 == br.ext[maths.Min(len(br.ext), 1):] {
+			f = append(f, wordlistRecord{"", br.file, "." + e, f83, e83, 0})
+		}
+	}
+
+	// Logging
+	if len(f) > 0 {
+		log.WithFields(log.Fields{"file": br.file, "ext": br.ext, "count": len(f)}).Info("Extension expansion found candidates")
+	}
+
+	return f
+
 }
 
-// Scan starts enumeration of the given URL
-func Scan(urls []string, hc *http.Client, st *httpStats, wc wordlistConfig, mk markers) {
+// longerExtensions returns up to ten extensions from the wordlist which are truncated to the extension of one of the
+// given results, most common first, leaving out any already tried with --expand-ext
+func (s *Scanner) longerExtensions(rs []resultOutput) []string {
+
+	// Note the truncated extensions and those already tried
+	short, tried := make(map[string]struct{}), make(map[string]struct{})
+	for _, r := range rs {
+		short[strings.ToUpper(strings.TrimPrefix(r.Ext, "."))] = struct{}{}
+	}
+	if s.opts.ExpandExt {
+		for _, e := range strings.Split(s.opts.ExpandExtList, ",") {
+			tried[strings.ToLower(strings.TrimPrefix(strings.TrimSpace(e), "."))] = struct{}{}
+		}
+	}
+
+	// Count the longer wordlist extensions which share an 8.3 form with a truncated extension
+	count := make(map[string]int)
+	for _, c := range s.wordlist.wordlist {
+		e := strings.ToLower(strings.TrimPrefix(c.extension, "."))
+		if _, ok := short[strings.ToUpper(c.extension83)]; !ok || len(e) <= len(c.extension83) {
+			continue
+		}
+		if _, ok := tried[e]; !ok {
+			count[e]++
+		}
+	}
+
+	// Most common first
+	el := make([]string, 0, len(count))
+	for e := range count {
+		el = append(el, e)
+	}
+	sort.Slice(el, func(i, j int) bool {
+		if count[el[i]] != count[el[j]] {
+			return count[el[i]] > count[el[j]]
+		}
+		return el[i] < el[j]
+	})
+	if len(el) > 10 {
+		el = el[:10]
+	}
+
+	return el
+
+}
+
+// readShortNames reads a list of 8.3 short names from a file, one per line, skipping blank lines and comments
+func readShortNames(path string) ([]baseRequest, error) {
+
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open short name list: %w", err)
+	}
+	defer fh.Close()
+
+	var brs []baseRequest
+	sc := bufio.NewScanner(fh)
+	for n := 1; sc.Scan(); n++ {
+		l := strings.TrimSpace(sc.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		m := shortNameRegex.FindStringSubmatch(strings.ToUpper(l))
+		if m == nil {
+			return nil, fmt.Errorf("%s line %d: not an 8.3 short name: %s", path, n, l)
+		}
+		brs = append(brs, baseRequest{file: m[1], tilde: m[2], ext: m[3]})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read short name list: %w", err)
+	}
+	if len(brs) == 0 {
+		return nil, fmt.Errorf("no short names found in %s", path)
+	}
+
+	return brs, nil
+
+}
+
+// readExtensions reads a list of extensions from a file (one per line) or a comma-separated list, returning their
+// unique 8.3 forms
+func readExtensions(list string) ([]string, error) {
+
+	// Read from a file if one exists with this name
+	var es []string
+	if b, err := os.ReadFile(list); err == nil {
+		es = strings.Split(string(b), "\n")
+	} else if os.IsNotExist(err) {
+		es = strings.Split(list, ",")
+	} else {
+		return nil, fmt.Errorf("unable to read extensions file: %s", err)
+	}
+
+	// Convert each extension to its 8.3 form
+	var el []string
+	seen := make(map[string]struct{})
+	for _, e := range es {
+		e = strings.TrimPrefix(strings.TrimSpace(e), ".")
+		if e == "" || strings.HasPrefix(e, "#") {
+			continue
+		}
+		_, _, e83 := shortutil.Gen8dot3("x", e)
+		if _, ok := seen[e83]; ok || e83 == "" {
+			continue
+		}
+		seen[e83] = struct{}{}
+		el = append(el, e83)
+	}
+	if len(el) == 0 {
+		return nil, fmt.Errorf("no extensions found in %s", list)
+	}
+
+	return el, nil
+
+}
+
+// autodechecksum tries to reconstitute Windows checksummed filenames
+func autodechecksum(ac *attackConfig, br baseRequest) []wordlistRecord {
+
+	// Get the 1-2 prefix letters and potential checksum
+	l := 2 - (6 - len(br.file))
+	prefix, checksum := br.file[:l], br.file[l:]
+	log.WithFields(log.Fields{"file": br.file, "prefix": prefix, "checksum": checksum}).Info("Possible checksummed alias")
+
+	// Match the prefix and extension against wordlist entries with the same checksum
+	var fs = make(map[string]wordlistRecord)
+	for _, record := range ac.wordlist.byChecksum[checksum] {
+		if strings.HasPrefix(strings.ToUpper(record.filename), prefix) && strings.HasPrefix(strings.ToUpper(record.extension), br.ext) {
+			fs[record.filename+record.extension] = record
+		}
+	}
+
+	// Convert the guess set to a slice, most likely names first
+	f := make([]wordlistRecord, 0, len(fs))
+	for _, v := range fs {
+		f = append(f, v)
+	}
+	sortCandidates(f)
+
+	// Logging
+	if len(f) > 1 {
+		log.WithFields(log.Fields{"file": br.file, "ext": br.ext, "count": len(f)}).Info("Dechecksum found candidates")
+		log.WithFields(log.Fields{"candidates": f}).Trace("Dechecksum candidates")
+	}
+
+	// Return the slice
+	return f
+
+}
+
+// getStatuses fetches non-existent URLs and returns a list of response statuses (cached per URL, since
+// different hosts and directories can have different error pages)
+func (s *Scanner) getStatuses(ctx context.Context, sem *limiter, c wordlistRecord, br baseRequest, st *httpStats, ac *attackConfig) map[int]struct{} {
+
+	// Only sample each extension once at a time (anything else wanting it waits for the result)
+	sl := ac.sampleLock("status " + c.extension)
+	sl.Lock()
+	defer sl.Unlock()
+
+	// Returned cached statuses if they exist and aren't stale
+	ac.statusMutex.Lock()
+	cs, cached := ac.statusCache[c.extension]
+	if cached && len(cs.statuses) > 0 && !s.stale(cs.uses, cs.sampled) {
+		cs.uses++
+		ac.statusMutex.Unlock()
+		return cs.statuses
+	}
+	ac.statusMutex.Unlock()
+
+	// Use saved statuses from a previous run if this is the first time they've been needed
+	if !cached && s.baselines != nil {
+		s.baselines.Lock()
+		b, ok := s.baselines.Statuses[br.url+" "+c.extension]
+		s.baselines.Unlock()
+		if ok && len(b.Statuses) > 0 && time.Since(b.Sampled) < s.opts.CacheTTL {
+			statuses := make(map[int]struct{}, len(b.Statuses))
+			for _, s := range b.Statuses {
+				statuses[s] = struct{}{}
+			}
+			ac.statusMutex.Lock()
+			ac.statusCache[c.extension] = &statusSample{statuses, 1, b.Sampled}
+			ac.statusMutex.Unlock()
+			log.WithFields(log.Fields{"extension": c.extension, "statuses": statuses}).Info("Using saved non-existent file statuses")
+			return statuses
+		}
+	}
+
+	// Set loop count based on stability
+	l := 2
+	if s.opts.Stabilise {
+		l = 12
+	}
+
+	// Fetch random filenames with the autocomplete candidate file extension
+	statuses := make(map[int]struct{}, l)
+	for _, r := range s.sample(ctx, sem, st, br.url, randPaths(ac, l, c.extension)) {
+		if r != nil {
+			statuses[r.status] = struct{}{}
+		}
+	}
+
+	// Logging
+	log.WithFields(log.Fields{"extension": c.extension, "statuses": statuses}).Info("Got non-existent file statuses")
+
+	// Cache and return the statuses
+	ac.statusMutex.Lock()
+	ac.statusCache[c.extension] = &statusSample{statuses, 1, time.Now()}
+	ac.statusMutex.Unlock()
+	if s.baselines != nil {
+		var ss []int
+		for s := range statuses {
+			ss = append(ss, s)
+		}
+		s.baselines.Lock()
+		s.baselines.Statuses[br.url+" "+c.extension] = savedStatuses{ss, time.Now()}
+		s.baselines.Unlock()
+	}
+	return statuses
+
+}
+
+// warmup makes a few requests at once to open keep-alive connections and measure the server's typical response
+// time, recording the median latency and warning if it's close to the request timeout
+func (s *Scanner) warmup(ctx context.Context, st *httpStats, url string) {
+
+	// Time each request
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var ts []time.Duration
+	for i := 0; i < warmupRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t := time.Now()
+			if _, _, err := s.fetch(ctx, st, "GET", url); err == nil {
+				mu.Lock()
+				ts = append(ts, time.Since(t))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Bail if nothing came back
+	if len(ts) == 0 {
+		log.WithFields(log.Fields{"url": url}).Warn("Warm-up requests failed")
+		return
+	}
+
+	// Record the median latency
+	sort.Slice(ts, func(i, j int) bool { return ts[i] < ts[j] })
+	m := ts[len(ts)/2]
+	st.Lock()
+	st.latency = m
+	st.Unlock()
+	log.WithFields(log.Fields{"url": url, "latency": m, "responses": len(ts)}).Info("Warm-up complete")
+
+	// Warn if slow responses are likely to time out during enumeration
+	if t := time.Duration(s.opts.Timeout) * time.Second; t > 0 && m*4 > t {
+		log.WithFields(log.Fields{"url": url, "latency": m.Round(time.Millisecond), "timeout": t}).Warn("Server is slow compared to the request timeout, some requests may time out and be missed (try a higher -t)")
+	}
+
+}
+
+// isSoft404 checks whether the server returns a 200 for random non-existent files, as custom error pages and
+// single page apps often do
+func (s *Scanner) isSoft404(ctx context.Context, st *httpStats, ac *attackConfig, url string) bool {
+
+	for i := 0; i < 3; i++ {
+		path := randPath(ac.rng, ac.rng.Intn(4)+8, 0, alphanum) + ".aspx"
+		if res, _, err := s.fetchWith(ctx, s.followClient, st, "GET", url+path); err != nil || res.StatusCode != 200 {
+			return false
+		}
+	}
+	return true
+
+}
+
+// getDistances calculates response distances for the given URL
+func (s *Scanner) getDistances(ctx context.Context, sem *limiter, c wordlistRecord, br baseRequest, st *httpStats, ac *attackConfig) map[int]distances {
+
+	// Only sample each extension once at a time (anything else wanting it waits for the result)
+	sl := ac.sampleLock("distance " + c.extension)
+	sl.Lock()
+	defer sl.Unlock()
+
+	// Return distances if cached and not stale
+	ac.distanceMutex.Lock()
+	cd, cached := ac.distanceCache[c.extension]
+	if cached && len(cd.dists) > 0 && !s.stale(cd.uses, cd.sampled) {
+		cd.uses++
+		ac.distanceMutex.Unlock()
+		return cd.dists
+	}
+	ac.distanceMutex.Unlock()
+
+	// Use saved distances from a previous run if this is the first time they've been needed
+	if !cached && s.baselines != nil {
+		s.baselines.Lock()
+		b, ok := s.baselines.Distances[br.url+" "+c.extension]
+		s.baselines.Unlock()
+		if ok && len(b.Distances) > 0 && time.Since(b.Sampled) < s.opts.CacheTTL {
+			dists := make(map[int]distances, len(b.Distances))
+			for s, d := range b.Distances {
+				dists[s] = distances{d.Distance, d.Body}
+			}
+			ac.distanceMutex.Lock()
+			ac.distanceCache[c.extension] = &distanceSample{dists, 1, b.Sampled}
+			ac.distanceMutex.Unlock()
+			log.WithFields(log.Fields{"extension": c.extension}).Info("Using saved Levenshtein distances")
+			return dists
+		}
+	}
+
+	// Status
+	log.WithFields(log.Fields{"url": br.url, "extension": c.extension}).Info("Sampling responses for Levenshtein distance calculation")
+
+	// Set loop count based on stability
+	l := 4
+	if s.opts.Stabilise {
+		l = 24
+	}
+
+	// Sample some random URLs and calculate distances between the first 1k of the response body
+	bodies := make(map[int][]string, l)
+	highdist := make(map[int]float32, l)
+	dists := make(map[int]distances)
+	for _, r := range s.sample(ctx, sem, st, br.url, randPaths(ac, l, c.extension)) {
+
+		// Skip failed requests
+		if r == nil {
+			continue
+		}
+
+		// Use the start of the body as the sample (an empty body still needs a sample)
+		body := string(r.body)
+		for j := 0; j < len(bodies[r.status])-1; j++ {
+
+			// Calculate Levenshtein distance
+			ld := levenshtein.Distance(bodies[r.status][j], body)
+
+			// Turn the distance into a percentage
+			lp := float32(ld) / float32(maths.Max(len(bodies[r.status][j]), len(body)))
+
+			// Store the highest distance and corresponding response for later comparison
+			if dists[r.status] == (distances{}) || lp > highdist[r.status] {
+				dists[r.status] = distances{lp, body}
+				highdist[r.status] = lp
+			}
+
+		}
+
+		// Save the response sample
+		bodies[r.status] = append(bodies[r.status], body)
+
+	}
+
+	// Log calculated distances
+	for s, d := range dists {
+		log.WithFields(log.Fields{"extension": c.extension, "status": s, "distance": d.distance}).Info("Calculated Levenshtein distance")
+	}
+
+	// Cache and return
+	ac.distanceMutex.Lock()
+	ac.distanceCache[c.extension] = &distanceSample{dists, 1, time.Now()}
+	ac.distanceMutex.Unlock()
+	if s.baselines != nil {
+		sd := make(map[int]savedDistance, len(dists))
+		for s, d := range dists {
+			sd[s] = savedDistance{d.distance, d.body}
+		}
+		s.baselines.Lock()
+		s.baselines.Distances[br.url+" "+c.extension] = savedDistances{sd, time.Now()}
+		s.baselines.Unlock()
+	}
+	return dists
+
+}
+
+// sampleLock returns the lock held while sampling baselines for the given key
+func (ac *attackConfig) sampleLock(key string) *sync.Mutex {
+
+	ac.sampleMutex.Lock()
+	defer ac.sampleMutex.Unlock()
+	if ac.sampleLocks == nil {
+		ac.sampleLocks = make(map[string]*sync.Mutex)
+	}
+	if ac.sampleLocks[key] == nil {
+		ac.sampleLocks[key] = new(sync.Mutex)
+	}
+	return ac.sampleLocks[key]
+
+}
+
+// randPaths returns random filenames with the given extension, generated up front so they don't depend on the
+// order requests complete in
+func randPaths(ac *attackConfig, n int, ext string) []string {
+	ps := make([]string, n)
+	for i := range ps {
+		ps[i] = randPath(ac.rng, ac.rng.Intn(4)+8, 0, alphanum) + ext
+	}
+	return ps
+}
+
+// sample fetches the given paths following redirects, returning the responses in the same order (nil for any that
+// failed); the caller already holds one of the scan's slots, so the paths are fetched in that slot plus any others
+// which are free (it never waits for one, which could deadlock if every slot is held by something sampling)
+func (s *Scanner) sample(ctx context.Context, sem *limiter, st *httpStats, url string, paths []string) []*sampleResponse {
+
+	// Queue up the paths
+	rs := make([]*sampleResponse, len(paths))
+	q := make(chan int, len(paths))
+	for i := range paths {
+		q <- i
+	}
+	close(q)
+
+	// Fetch from the queue until it's empty
+	work := func() {
+		for i := range q {
+			if res, b, err := s.fetchWith(ctx, s.followClient, st, "GET", url+paths[i]); err == nil {
+				rs[i] = &sampleResponse{res.StatusCode, b}
+			}
+		}
+	}
+
+	// Share the work with helpers in any free slots, and do the rest in this one
+	var wg sync.WaitGroup
+	for n := 1; n < len(paths) && sem.tryAcquire(); n++ {
+		wg.Add(1)
+		go func() {
+			defer func() {
+				sem.release(st)
+				wg.Done()
+			}()
+			work()
+		}()
+	}
+	work()
+	wg.Wait()
+
+	return rs
+
+}
+
+// stale checks whether cached baseline samples should be refreshed based on their use count and age
+func (s *Scanner) stale(uses int, sampled time.Time) bool {
+	if s.opts.ResampleInterval > 0 && uses >= s.opts.ResampleInterval {
+		return true
+	}
+	return s.opts.ResampleAge > 0 && time.Since(sampled) >= s.opts.ResampleAge
+}
+
+// loadBaselines reads saved autocomplete baselines from a file, discarding any that have expired (a missing file is fine)
+func loadBaselines(path string, ttl time.Duration) (*baselineCache, error) {
+
+	// Read and parse the file
+	bc := &baselineCache{Statuses: make(map[string]savedStatuses), Distances: make(map[string]savedDistances)}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return bc, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, bc); err != nil {
+		return nil, err
+	}
+	if bc.Statuses == nil {
+		bc.Statuses = make(map[string]savedStatuses)
+	}
+	if bc.Distances == nil {
+		bc.Distances = make(map[string]savedDistances)
+	}
+
+	// Discard expired baselines
+	for k, v := range bc.Statuses {
+		if time.Since(v.Sampled) >= ttl {
+			delete(bc.Statuses, k)
+		}
+	}
+	for k, v := range bc.Distances {
+		if time.Since(v.Sampled) >= ttl {
+			delete(bc.Distances, k)
+		}
+	}
+
+	return bc, nil
+
+}
+
+// saveBaselines writes autocomplete baselines to a file
+func saveBaselines(path string, bc *baselineCache) error {
+
+	// Serialise the baselines
+	bc.Lock()
+	b, err := json.Marshal(bc)
+	bc.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0600)
+
+}
+
+// randPath returns a random path built with the provided characters
+func randPath(rng *rand.Rand, l int, d int, chars string) string {
+	c := len(chars)
+	b := make([]byte, l)
+	for i := range b {
+		b[i] = chars[rng.Intn(c)]
+	}
+	for i := 0; i < d; i++ {
+		b[rng.Intn(l)] = '.'
+	}
+	return pathEscape(string(b))
+}
+
+// newRand returns a goroutine-safe random number generator for the given URL, seeded from --seed and the
+// URL if a seed was given so that each URL's random paths are reproducible regardless of scan order
+func (s *Scanner) newRand(url string) *rand.Rand {
+	seed := time.Now().UTC().UnixNano()
+	if s.opts.Seed != 0 {
+		h := fnv.New64a()
+		h.Write([]byte(url))
+		seed = s.opts.Seed ^ int64(h.Sum64())
+	}
+	return newLockedRand(seed)
+}
+
+// newLockedRand returns a random number generator with the given seed which is safe to share between goroutines
+func newLockedRand(seed int64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(seed).(rand.Source64)})
+}
+
+// Int63 implements rand.Source
+func (s *lockedSource) Int63() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.src.Int63()
+}
+
+// Uint64 implements rand.Source64
+func (s *lockedSource) Uint64() uint64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.src.Uint64()
+}
+
+// Seed implements rand.Source
+func (s *lockedSource) Seed(seed int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.src.Seed(seed)
+}
+
+// printHuman prints human readable output if enabled
+func (s *Scanner) printHuman(a ...any) {
+	if s.opts.Output == "human" {
+		fmt.Fprintln(s.out, a...)
+	}
+}
+
+// printTable prints results as a table with columns sized to fit (colour is disabled automatically when not on a TTY)
+func (s *Scanner) printTable(results []resultOutput) {
+
+	// Bail if table output isn't enabled
+	if s.opts.Output != "human" || !s.opts.Table || len(results) == 0 {
+		return
+	}
+
+	// Build the rows and work out the column widths
+	rows := make([][4]string, len(results))
+	var w [4]int
+	for i, r := range results {
+		rows[i][0] = r.ShortName
+		if r.Type == "directory" {
+			rows[i][0] += "/"
+		}
+		rows[i][1] = r.Partname
+		if r.Fullname != "" && s.opts.FullUrl {
+			rows[i][2] = r.BaseUrl + pathEscape(strings.ToLower(r.Fullname))
+		} else {
+			rows[i][2] = r.Fullname
+		}
+		if r.Confirmed != nil && *r.Confirmed {
+			rows[i][3] = "confirmed"
+		} else if r.Confirmed != nil {
+			rows[i][3] = "unconfirmed"
+		}
+		if r.Status != 0 {
+			p := &probeResult{status: r.Status, length: r.ContentLength, contentType: r.ContentType}
+			rows[i][3] = strings.TrimSpace(rows[i][3] + " [" + p.String() + "]")
+		}
+		if r.ProbeUrl != "" {
+			rows[i][3] = strings.TrimSpace(rows[i][3] + " [" + r.ProbeMethod + " " + r.ProbeUrl + "]")
+		}
+		for j, c := range rows[i] {
+			w[j] = maths.Max(w[j], len(c))
+		}
+	}
+
+	// Output the rows, padding before colouring so colour codes don't affect the alignment
+	for i, r := range rows {
+		fp := fmt.Sprintf("%-*s", w[1], r[1])
+		if results[i].FullMatch {
+			fp = color.HiBlackString(fp)
+		}
+		ff := color.HiGreenString("%-*s", w[2], r[2])
+		fc := color.HiBlackString(r[3])
+		if strings.HasPrefix(r[3], "confirmed") {
+			fc = color.HiGreenString(r[3])
+		}
+		s.printHuman(strings.TrimRight(fmt.Sprintf("%-*s  %s  %s  %s", w[0], r[0], fp, ff, fc), " "))
+	}
+
+}
+
+// printXML prints all results as a single XML document if enabled, with a host element per scanned URL
+func (s *Scanner) printXML(rb *resultBuffer) {
+
+	// Bail if XML output isn't enabled
+	if s.opts.Output != "xml" {
+		return
+	}
+
+	// Build a host element for each URL, then add its results
+	x := xmlOutput{Version: version}
+	hosts := make(map[string]int)
+	for _, s := range rb.statuses {
+		hosts[s.Url] = len(x.Hosts)
+		x.Hosts = append(x.Hosts, xmlHost{Url: s.Url, Server: s.Server, Vulnerable: s.Vulnerable, Unreachable: s.Unreachable, IISVersion: s.IISVersion})
+	}
+	for _, r := range rb.results {
+		i, ok := hosts[r.BaseUrl]
+		if !ok {
+			continue
+		}
+		x.Hosts[i].Results = append(x.Hosts[i].Results, xmlResult{r.Type, r.ShortName, r.Partname, r.Fullname, r.Confirmed, r.Status, r.ContentLength, r.ContentType, r.ProbeUrl, r.ProbeMethod})
+	}
+
+	// Output the document
+	b, err := xml.MarshalIndent(x, "", "  ")
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Unable to generate XML output")
+		return
+	}
+	fmt.Fprintln(s.out, xml.Header+string(b))
+
+}
+
+// printUnresolved prints a section listing the results without a full name, grouped by extension
+func (s *Scanner) printUnresolved(results []resultOutput) {
+
+	// Group unresolved results by extension
+	groups := make(map[string][]resultOutput)
+	for _, r := range results {
+		if !r.FullMatch {
+			groups[r.Ext] = append(groups[r.Ext], r)
+		}
+	}
+	if len(groups) == 0 {
+		return
+	}
+
+	// Output each group
+	exts := make([]string, 0, len(groups))
+	for e := range groups {
+		exts = append(exts, e)
+	}
+	sort.Strings(exts)
+	s.printHuman(color.New(color.FgWhite, color.Bold).Sprint("Unresolved names (might require some fuzzing):"))
+	for _, e := range exts {
+		sort.Slice(groups[e], func(i, j int) bool {
+			a, b := groups[e][i], groups[e][j]
+			if a.BaseUrl != b.BaseUrl {
+				return a.BaseUrl < b.BaseUrl
+			}
+			return a.File+a.Tilde < b.File+b.Tilde
+		})
+		if e == "" {
+			s.printHuman(color.HiBlackString("(no extension)"))
+		} else {
+			s.printHuman(color.HiBlackString(e))
+		}
+		for _, r := range groups[e] {
+			sn := r.ShortName
+			if r.Type == "directory" {
+				sn += "/"
+			}
+			s.printHuman(fmt.Sprintf("  %-20s %-28s %s", sn, r.FuzzPattern, r.BaseUrl))
+		}
+	}
+	s.printHuman()
+
+}
+
+// printSuggestions prints suggested next steps if enabled
+func (s *Scanner) printSuggestions(rb *resultBuffer) {
+
+	if !s.opts.Suggest {
+		return
+	}
+	ss := s.suggestions(rb)
+	if len(ss) == 0 {
+		return
+	}
+	s.printHuman(color.New(color.FgWhite, color.Bold).Sprint("Suggested next steps:"))
+	for _, sg := range ss {
+		s.printHuman("  • " + sg)
+	}
+	s.printHuman()
+
+}
+
+// suggestions works out what's worth trying next based on what the scan found and how detection went
+func (s *Scanner) suggestions(rb *resultBuffer) []string {
+
+	var ss []string
+
+	// Nothing was vulnerable, so suggest trying harder
+	vuln := false
+	for _, st := range rb.statuses {
+		vuln = vuln || st.Vulnerable
+	}
+	if len(rb.statuses) > 0 && !vuln {
+		if s.opts.Patience < 1 {
+			ss = append(ss, "No URLs looked vulnerable; try -p 1 to probe more methods and suffixes")
+		}
+		if s.opts.WildcardStyle == "star" {
+			ss = append(ss, "No URLs looked vulnerable; try --wildcard-style auto in case * or ? are being blocked")
+		}
+		return ss
+	}
+
+	// Group the unresolved names by extension, and spot checksummed aliases and directories
+	unresolved := make(map[string][]resultOutput)
+	var checksummed, dirs, expandable []resultOutput
+	for _, r := range rb.results {
+		if r.FullMatch {
+			continue
+		}
+		unresolved[r.Ext] = append(unresolved[r.Ext], r)
+		switch {
+		case r.Type == "directory":
+			dirs = append(dirs, r)
+		case checksumRegex.MatchString(r.File) && r.Tilde == "~1":
+			checksummed = append(checksummed, r)
+		}
+		if len(r.Ext) > s.opts.MaxExtLen {
+			expandable = append(expandable, r)
+		}
+	}
+
+	// Unresolved names by extension, most common first
+	exts := make([]string, 0, len(unresolved))
+	for e := range unresolved {
+		exts = append(exts, e)
+	}
+	sort.Slice(exts, func(i, j int) bool {
+		if len(unresolved[exts[i]]) != len(unresolved[exts[j]]) {
+			return len(unresolved[exts[i]]) > len(unresolved[exts[j]])
+		}
+		return exts[i] < exts[j]
+	})
+	if len(exts) > 0 {
+		var n int
+		var common []string
+		for i, e := range exts {
+			n += len(unresolved[e])
+			if i < 3 {
+				name := e
+				if e == "" {
+					name = "no extension"
+				}
+				common = append(common, fmt.Sprintf("%s ×%d", name, len(unresolved[e])))
+			}
+		}
+		ss = append(ss, fmt.Sprintf("%d names couldn't be resolved (most common: %s); try fuzzing them with the patterns listed above or a more specific wordlist", n, strings.Join(common, ", ")))
+	}
+
+	// Checksummed aliases can only be resolved with a rainbow table
+	if len(checksummed) > 0 && (s.wordlist == nil || !s.wordlist.isRainbow) {
+		ss = append(ss, fmt.Sprintf("%d names look like checksummed aliases (e.g. %s); build a rainbow table with shortutil wordlist and pass it with -w to resolve them", len(checksummed), checksummed[0].ShortName))
+	}
+
+	// Unresolved directories are skipped unless recursing by short name
+	if len(dirs) > 0 && !s.opts.RecurseShort && !s.opts.NoRecurse {
+		ss = append(ss, fmt.Sprintf("%d directories couldn't be resolved (e.g. %s/); try --recurse-short to scan them by their short names", len(dirs), dirs[0].ShortName))
+	}
+
+	// Three character extensions are often longer (e.g. .ASP for .aspx), and --expand-ext only tries what's in
+	// --expand-ext-list, so suggest the longer extensions from the wordlist that share an 8.3 form with an unresolved name
+	if el := s.longerExtensions(expandable); len(el) > 0 {
+		ss = append(ss, fmt.Sprintf("%d unresolved names have three character extensions which may be truncated (e.g. %s); try --expand-ext --expand-ext-list %s", len(expandable), expandable[0].ShortName, strings.Join(el, ",")))
+	}
+
+	// Suggest skipping detection on rescans if every URL settled on the same options
+	if len(rb.detections) > 0 && s.opts.Method == "" {
+		d, same := rb.detections[0], true
+		for _, o := range rb.detections[1:] {
+			same = same && o.method == d.method && o.suffix == d.suffix && o.mk == d.mk && o.wc == d.wc
+		}
+		if same && d.method != "" {
+			ss = append(ss, fmt.Sprintf("Detection settled on the same options for every URL; skip it on rescans with --method %s --suffix '%s' --status-pos %d --status-neg %d", d.method, d.suffix, d.mk.statusPos, d.mk.statusNeg))
+		}
+	}
+
+	// Method-based autocomplete is the quietest, so suggest forcing it if it was autoselected everywhere
+	if len(rb.detections) > 0 && s.opts.Autocomplete == "auto" {
+		method := true
+		for _, d := range rb.detections {
+			method = method && d.autocomplete == "method"
+		}
+		if method {
+			ss = append(ss, "The server supports method-based existence checks; use -a method on rescans to skip autodetection")
+		}
+	}
+
+	return ss
+
+}
+
+// printTree prints results as a directory tree if enabled, placing each result under the directories in its base URL
+func (s *Scanner) printTree(results []resultOutput) {
+
+	// Bail if tree output isn't enabled
+	if s.opts.Output != "tree" {
+		return
+	}
+
+	// Output each host's tree
+	writeTree(s.out, buildTree(results))
+
+}
+
+// buildTree arranges results into a tree of directories, with a root node per host
+func buildTree(results []resultOutput) *treeNode {
+
+	// Build the tree, with a root per host
+	root := &treeNode{children: make(map[string]*treeNode)}
+	for _, r := range results {
+
+		// Split the base URL into its host and directories
+		u, err := nurl.Parse(r.BaseUrl)
+		if err != nil {
+			continue
+		}
+		path := []string{u.Scheme + "://" + u.Host + "/"}
+		for _, d := range strings.Split(strings.Trim(u.Path, "/"), "/") {
+			if d != "" {
+				path = append(path, d+"/")
+			}
+		}
+
+		// Label the result with its full name if known, and its short name
+		sn := r.ShortName
+		name, label := sn, sn
+		if r.Fullname != "" {
+			name, label = r.Fullname, r.Fullname+" "+color.HiBlackString("("+sn+")")
+		}
+		if r.Type == "directory" {
+			name += "/"
+			label = strings.Replace(label, " ", "/ ", 1)
+			if r.Fullname == "" {
+				label += "/"
+			}
+		}
+
+		// Walk down the tree, adding nodes as needed
+		n := root
+		for _, d := range append(path, name) {
+			k := strings.ToLower(d)
+			if n.children[k] == nil {
+				n.children[k] = &treeNode{label: d, children: make(map[string]*treeNode)}
+			}
+			n = n.children[k]
+		}
+		n.label = label
+
+	}
+
+	return root
+
+}
+
+// writeTree writes each host's tree
+func writeTree(w io.Writer, root *treeNode) {
+	for _, k := range sortedKeys(root.children) {
+		fmt.Fprintln(w, color.New(color.FgWhite, color.Bold).Sprint(root.children[k].label))
+		printTreeNode(w, root.children[k], "")
+	}
+}
+
+// printTreeNode prints the children of a tree node, indented with the given prefix
+func printTreeNode(w io.Writer, n *treeNode, prefix string) {
+	ks := sortedKeys(n.children)
+	for i, k := range ks {
+		branch, indent := "├── ", "│   "
+		if i == len(ks)-1 {
+			branch, indent = "└── ", "    "
+		}
+		fmt.Fprintln(w, prefix+branch+n.children[k].label)
+		printTreeNode(w, n.children[k], prefix+indent)
+	}
+}
+
+// sortedKeys returns the keys of a tree node's children in sorted order
+func sortedKeys(m map[string]*treeNode) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// printJSON prints JSON formatted output if enabled
+func (s *Scanner) printJSON(o any) {
+	if s.opts.Output == "json" {
+		j, _ := json.Marshal(o)
+		fmt.Fprintln(s.out, string(j))
+	}
+}
+
+// getCharacters finds out which characters are in use in filenames and extensions for each tilde level
+func (s *Scanner) getCharacters(ctx context.Context, st *httpStats, ac *attackConfig, mk markers, url string) {
+
+	// Loop twice, first to check file characters, then to check extension characters
+	ac.fileChars, ac.extChars = make(map[string]string), make(map[string]string)
+	for i := 0; i < 2; i++ {
+
+		// Loop through characters and tilde levels
+		for _, char := range s.opts.Characters {
+			for _, tilde := range ac.tildes {
+
+				// Set the check URL and character map
+				var cu string
+				var cm map[string]string
+				if i == 0 {
+					cm = ac.fileChars
+					cu = url + ac.wc.star + pathEscape(string(char)) + ac.wc.star + tilde + ac.wc.star + ac.suffix
+				} else {
+					cm = ac.extChars
+					cu = url + ac.wc.star + tilde + ac.wc.star + pathEscape(string(char)) + ac.wc.star + ac.suffix
+				}
+
+				// Higher tildes only exist when a lower tilde shares the stem, so when probing the first tilde
+				// first only characters seen there need to be checked for the others
+				if s.opts.CharsProbeFirst && tilde != ac.tildes[0] && !strings.ContainsRune(cm[ac.tildes[0]], char) {
+					continue
+				}
+
+				// Add hits to the character map
+				res, _, err := s.fetch(ctx, st, ac.method, cu)
+				if err == nil && res.StatusCode != mk.statusNeg {
+					cm[tilde] = cm[tilde] + string(char)
+				}
+
+			}
+		}
+	}
+
+	// Status
+	log.WithFields(log.Fields{"fileChars": ac.fileChars, "extChars": ac.extChars}).Info("Built character set")
+
+}
+
+// coversTildes checks whether a character set has characters for each of the given tilde levels
+func coversTildes(cs charset, tildes []string) bool {
+	for _, t := range tildes {
+		if _, ok := cs.fileChars[t]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// earlyTermination checks the results of an enumeration for signs that it ended prematurely (such as
+// server instability causing branches to be pruned) and returns the reasons for suspicion
+func earlyTermination(ac *attackConfig, requests int, retries int, failures int) []string {
+
+	var rs []string
+
+	// Characters were found but nothing (or next to nothing) was enumerated
+	n := ac.fileCount + ac.dirCount + ac.partialCount
+	chars := false
+	for _, c := range ac.fileChars {
+		chars = chars || len(c) > 0
+	}
+	if n == 0 && chars {
+		rs = append(rs, "characters were found but no short names were enumerated")
+	}
+
+	// Lots of requests had to be retried or failed outright (requests only counts those which got a response)
+	total := requests + failures
+	if total > 0 && float64(retries)/float64(total) > 0.1 {
+		rs = append(rs, fmt.Sprintf("%d retries were needed over %d requests", retries, total))
+	}
+	if total > 0 && float64(failures)/float64(total) > 0.1 {
+		rs = append(rs, fmt.Sprintf("%d of %d requests failed", failures, total))
+	}
+
+	// A tilde level had characters but no complete stems
+	if n > 0 {
+		for _, t := range ac.tildes {
+			found := false
+			for _, ts := range ac.stems {
+				if _, ok := ts[t]; ok {
+					found = true
+					break
+				}
+			}
+			if !found && len(ac.fileChars[t]) > 0 {
+				rs = append(rs, "characters were found for "+t+" but no short names")
+			}
+		}
+	}
+
+	return rs
+
+}
+
+// negativeStatus picks the negative status code from a set of samples, returning the status, the distribution of
+// statuses seen, and whether the most common status made up at least the given fraction of samples
+func negativeStatus(statuses []int, threshold float64) (int, map[int]int, bool) {
+
+	// Count each status and find the most common
+	dist := make(map[int]int)
+	var mode int
+	for _, s := range statuses {
+		dist[s]++
+		if dist[s] > dist[mode] || (dist[s] == dist[mode] && s < mode) {
+			mode = s
+		}
+	}
+
+	// Check the most common status is stable enough to use
+	if len(statuses) == 0 {
+		return 0, dist, false
+	}
+	return mode, dist, float64(dist[mode]) >= threshold*float64(len(statuses))
+
+}
+
+// confirmMarkers checks that manually specified markers behave as expected (a negative probe must return the
+// negative status) and returns the tildes which return the positive status
+func (s *Scanner) confirmMarkers(ctx context.Context, st *httpStats, url string, method string, suffix string, wc wildcards, statusPos int, statusNeg int) []string {
+
+	// Confirm the negative status
+	res, _, err := s.fetch(ctx, st, method, fmt.Sprintf("%s%s~0%s%s", url, wc.star, wc.star, suffix))
+	if err != nil || res.StatusCode != statusNeg {
+		log.WithFields(log.Fields{"url": url, "method": method, "suffix": suffix, "statusNeg": statusNeg}).Warn("Negative probe didn't return the given negative status")
+		return nil
+	}
+
+	// Find the tildes which return the positive status
+	var tildes []string
+	for i := 1; i <= 4; i++ {
+		res, _, err := s.fetch(ctx, st, method, fmt.Sprintf("%s%s~%d%s%s", url, wc.star, i, wc.star, suffix))
+		if err == nil && res.StatusCode == statusPos {
+			tildes = append(tildes, fmt.Sprintf("~%d", i))
+		}
+	}
+	if len(tildes) == 0 {
+		log.WithFields(log.Fields{"url": url, "method": method, "suffix": suffix, "statusPos": statusPos}).Warn("No probes returned the given positive status")
+	}
+
+	return tildes
+
+}
+
+// fingerprintIIS estimates the range of IIS versions a server could be running from its response headers and the
+// status it returned for an invalid method (0 if unknown), returning the range and the evidence for it (or an
+// empty range if there's nothing to go on)
+func fingerprintIIS(h http.Header, badMethod int) (string, []string) {
+
+	// Narrow the range of versions with each piece of evidence
+	lo, hi := 0, len(iisVersions)-1
+	var ev []string
+	narrow := func(l, u int, why string) {
+		lo, hi = maths.Max(lo, l), maths.Min(hi, u)
+		ev = append(ev, why)
+	}
+	v := func(s string) int {
+		for i, iv := range iisVersions {
+			if iv == s {
+				return i
+			}
+		}
+		return -1
+	}
+	server := strings.Join(h.Values("Server"), ", ")
+
+	// The banner, if it hasn't been hidden
+	if m := iisBannerRegex.FindStringSubmatch(server); m != nil && v(m[1]) >= 0 {
+		narrow(v(m[1]), v(m[1]), "Server header says IIS "+m[1])
+	}
+
+	// HTTP.sys 2.0 (which answers some requests itself) shipped with Windows Server 2008
+	if strings.Contains(server, "Microsoft-HTTPAPI/2.0") {
+		narrow(v("7.0"), hi, "HTTP.sys 2.0 answered (Windows Server 2008 or later)")
+	}
+
+	// IIS 7 onwards rejects unknown methods with a 405, while earlier versions return a 501
+	switch badMethod {
+	case 405:
+		narrow(v("7.0"), hi, "invalid methods get 405 Method Not Allowed (IIS 7 or later)")
+	case 501:
+		narrow(lo, v("6.0"), "invalid methods get 501 Not Implemented (IIS 6 or earlier)")
+	}
+
+	// ASP.NET 1.x doesn't run on IIS 7 or later
+	if a := h.Get("X-Aspnet-Version"); strings.HasPrefix(a, "1.") {
+		narrow(lo, v("6.0"), "ASP.NET "+a+" (IIS 6 or earlier)")
+	}
+
+	// Describe the range
+	switch {
+	case len(ev) == 0:
+		return "", nil
+	case lo > hi:
+		return "unknown (conflicting evidence)", ev
+	case lo == hi:
+		return iisVersions[lo], ev
+	case hi == len(iisVersions)-1:
+		return iisVersions[lo] + " or later", ev
+	default:
+		return iisVersions[lo] + " to " + iisVersions[hi], ev
+	}
+
+}
+
+// btoi converts a bool to an int
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// flushResults outputs a URL's buffered results along with their collision counts (as JSON or a table if requested)
+// and hands them over to the result buffer for output at the end of the scan
+func (s *Scanner) flushResults(ac *attackConfig, url string, mk markers, rb *resultBuffer) {
+
+	for _, o := range ac.results {
+		o.CollisionCount = len(ac.stems[o.File+o.Ext])
+		s.printJSON(o)
+	}
+	rb.Lock()
+	rb.results = append(rb.results, ac.results...)
+	rb.detections = append(rb.detections, detection{url, ac.method, ac.suffix, ac.autocomplete, ac.wc, mk})
+	rb.Unlock()
+	s.printTable(ac.results)
+
+}
+
+// resolveShortNames autocompletes the given short names on a URL without detection or enumeration, reporting each
+// one as if it had been enumerated
+func (s *Scanner) resolveShortNames(ctx context.Context, st *httpStats, ac *attackConfig, url string, parent string) {
+
+	ac.foundFiles = make(map[string]struct{})
+	ac.stems = make(map[string]map[string]struct{})
+	ac.statusCache = make(map[string]*statusSample)
+	ac.distanceCache = make(map[string]*distanceSample)
+
+	sem := newLimiter(s.opts.Concurrency, s.opts.Adaptive)
+	wg := new(sync.WaitGroup)
+	for _, br := range s.shortNames {
+		br.url, br.parent = url, parent
+		wg.Add(1)
+		go func(br baseRequest) {
+			sem.acquire()
+			defer func() {
+				sem.release(st)
+				wg.Done()
+			}()
+			if ctx.Err() == nil {
+				s.report(ctx, sem, st, ac, br, "")
+			}
+		}(br)
+	}
+	wg.Wait()
+
+}
+
+// getSummary returns a summary of the results found for the given URL
+func getSummary(ctx context.Context, url string, ac *attackConfig) summaryOutput {
+	return summaryOutput{
+		Type:         "summary",
+		Version:      version,
+		Url:          url,
+		Files:        ac.fileCount,
+		Directories:  ac.dirCount,
+		Partials:     ac.partialCount,
+		Method:       ac.method,
+		Suffix:       ac.suffix,
+		Autocomplete: ac.autocomplete,
+		TimedOut:     errors.Is(ctx.Err(), context.DeadlineExceeded),
+	}
+}
+
+// Scan enumerates short names on the given URL (and any directories found under it), returning everything found
+func (s *Scanner) Scan(ctx context.Context, url string) ([]Result, error) {
+	rs, _, err := s.scan(ctx, url)
+	return rs, err
+}
+
+// scan does the work for Scan, also returning the request stats
+func (s *Scanner) scan(ctx context.Context, url string) ([]Result, *httpStats, error) {
+
+	budget, bctx, cancel := s.newBudget(ctx)
+	defer cancel()
+	st := &httpStats{budget: budget}
+	rb := &resultBuffer{}
+	if _, errs := s.scanHost(bctx, []string{url}, st, markers{}, rb); len(errs) > 0 {
+		return nil, st, errs[0]
+	}
+	if budget != nil && budget.exhausted {
+		return rb.results, st, ErrMaxRequests
+	}
+	return rb.results, st, ctx.Err()
+
+}
+
+// scanAll starts enumeration of the given URLs, outputting results as it goes, and returns the number of URLs that
+// couldn't be scanned (the reasons have already been logged)
+func (s *Scanner) scanAll(ctx context.Context, urls []string, mk markers) int {
+
+	// Bound the whole scan if a maximum duration was requested
+	sctx, cancel := ctx, context.CancelFunc(func() {})
+	if s.opts.MaxDuration > 0 {
+		sctx, cancel = context.WithTimeout(sctx, s.opts.MaxDuration)
+	}
+	defer cancel()
+
+	// Stop the whole scan once the request budget (if any) runs out
+	budget, sctx, bcancel := s.newBudget(sctx)
+	defer bcancel()
+
+	// Group URLs by host so distinct hosts can be scanned in parallel or written to their own files (a single
+	// group keeps the original order)
+	groups := [][]string{urls}
+	if s.opts.HostsConcurrency > 1 || s.opts.OutputDir != "" {
+		groups = groupByHost(urls)
+	}
+
+	// Scan each group of URLs, each with its own stats so that pauses and the circuit breaker stay per-host
+	s.printJSON(eventOutput{Type: "event", Version: version, Event: "start", Urls: urls})
+	st := &httpStats{}
+	rt := &resultBuffer{}
+	var remaining, failed int
+	var mutex sync.Mutex
+	hs := make(chan struct{}, maths.Max(s.opts.HostsConcurrency, 1))
+	wg := new(sync.WaitGroup)
+	for _, g := range groups {
+		wg.Add(1)
+		hs <- struct{}{}
+		go func(g []string) {
+
+			// Waitgroup and semaphore handling
+			defer func() {
+				<-hs
+				wg.Done()
+			}()
+
+			// Route this host's output to its own file if requested
+			gs, grt := s, &resultBuffer{}
+			if s.opts.OutputDir != "" {
+				path := filepath.Join(s.opts.OutputDir, hostFilename(g[0], s.opts.Output))
+				fh, err := os.Create(path)
+				if err != nil {
+					log.WithFields(log.Fields{"file": path, "err": err}).Error("Unable to create output file, skipping host")
+					mutex.Lock()
+					failed += len(g)
+					mutex.Unlock()
+					return
+				}
+				defer fh.Close()
+				c := *s
+				c.out = fh
+				gs = &c
+			}
+
+			// Scan the hosts, outputting anything that's buffered to the host's own file
+			hst := &httpStats{budget: budget}
+			r, es := gs.scanHost(sctx, g, hst, mk, grt)
+			if s.opts.OutputDir != "" {
+				gs.printTree(grt.results)
+				gs.printUnresolved(grt.results)
+				gs.printXML(grt)
+			}
+
+			// Merge the results, failure count and stats
+			rt.Lock()
+			rt.results = append(rt.results, grt.results...)
+			rt.statuses = append(rt.statuses, grt.statuses...)
+			rt.detections = append(rt.detections, grt.detections...)
+			rt.Unlock()
+			mutex.Lock()
+			remaining += r
+			failed += len(es)
+			mutex.Unlock()
+			st.Lock()
+			st.bytesTx += hst.bytesTx
+			st.bytesRx += hst.bytesRx
+			st.requests += hst.requests
+			st.retries += hst.retries
+			st.errors += hst.errors
+			st.failures += hst.failures
+			st.timeouts += hst.timeouts
+			st.Unlock()
+
+		}(g)
+	}
+	wg.Wait()
+	s.printHuman()
+
+	// Output everything that's buffered unless it's already gone to per-host files
+	if s.opts.OutputDir == "" {
+
+		// Output the directory tree if requested
+		s.printTree(rt.results)
+
+		// List unresolved names separately, since they're the ones worth fuzzing by hand
+		s.printUnresolved(rt.results)
+
+		// Output the XML document if requested
+		s.printXML(rt)
+
+	}
+
+	// Suggest what to do next if requested
+	s.printSuggestions(rt)
+
+	// Warn if the scan was cut short
+	if budget != nil && budget.exhausted {
+		log.WithFields(log.Fields{"requests": s.opts.MaxRequests, "remaining": remaining}).Warn("Maximum number of requests reached, results are partial")
+	} else if sctx.Err() != nil {
+		log.WithFields(log.Fields{"duration": s.opts.MaxDuration, "remaining": remaining}).Warn("Maximum scan duration exceeded, results are partial")
+	}
+
+	// Fin
+	s.printHuman(fmt.Sprintf("%s Requests: %d; Retries: %d; Failures: %d; Timeouts: %d; Sent %d bytes; Received %d bytes", color.New(color.FgWhite, color.Bold).Sprint("Finished!"), st.requests, st.retries, st.failures, st.timeouts, st.bytesTx, st.bytesRx))
+	s.printJSON(statsOutput{Type: "statistics", Version: version, Requests: st.requests, Retries: st.retries, Failures: st.failures, Timeouts: st.timeouts, SentBytes: st.bytesTx, ReceivedBytes: st.bytesRx})
+
+	return failed
+
+}
+
+// groupByHost splits a list of URLs into groups by scheme and host, preserving the order within each group
+func groupByHost(urls []string) [][]string {
+
+	// Group URLs, noting the order hosts were first seen in
+	var hosts []string
+	groups := make(map[string][]string)
+	for _, url := range urls {
+		h := url
+		if bu, err := baseUrl(url); err == nil {
+			if u, err := nurl.Parse(bu); err == nil {
+				h = u.Scheme + "://" + u.Host
+			}
+		}
+		if _, ok := groups[h]; !ok {
+			hosts = append(hosts, h)
+		}
+		groups[h] = append(groups[h], url)
+	}
+
+	// Build the list of groups
+	var gs [][]string
+	for _, h := range hosts {
+		gs = append(gs, groups[h])
+	}
+	return gs
+
+}
+
+// hostFilename returns a safe filename for output about the host a URL is on (e.g. https_example.org_8443.json)
+func hostFilename(url string, output string) string {
+
+	// Use the scheme, host and port, falling back to the whole URL if it can't be parsed
+	h := url
+	if u, err := nurl.Parse(url); err == nil && u.Host != "" {
+		h = u.Scheme + "_" + u.Host
+	}
+	h = hostFilenameRegex.ReplaceAllString(h, "_")
+
+	// Pick an extension to suit the output format
+	switch output {
+	case "json":
+		return h + ".json"
+	case "xml":
+		return h + ".xml"
+	default:
+		return h + ".txt"
+	}
+
+}
+
+// scanHost scans each of the given URLs in turn (along with any directories discovered under them), returning
+// the number of URLs left unscanned if the scan was cut short and the errors for any given URLs which couldn't be
+// scanned (these are skipped rather than stopping the scan)
+func (s *Scanner) scanHost(sctx context.Context, urls []string, st *httpStats, mk markers, rt *resultBuffer) (int, []error) {
+
+	var errs []error
+
+	// Character sets discovered per host (reused when recursing into directories)
+	charsets := make(map[string]charset)
+
+	// The URL each discovered directory was found under
+	parents := make(map[string]string)
+
+	// When each URL given to the scan was started (for the host timeout, which covers its directories too)
+	started := make(map[string]time.Time)
+
+	// Loop through each URL
+	for len(urls) > 0 {
+
+		// Stop if the maximum scan duration has been exceeded
+		if sctx.Err() != nil {
+			break
+		}
+
+		// Pop off a URL
+		var url string
+		url, urls = urls[0], urls[1:]
+
+		// -----------------------------------------------
+		// Pre-flight: check that the server is accessible
+		// -----------------------------------------------
+
+		// Validate the URL and turn it into a base URL
+		bu, err := baseUrl(url)
+		if err != nil {
+			log.WithFields(log.Fields{"url": url, "error": err}).Error("Unable to parse URL, skipping")
+			errs = append(errs, fmt.Errorf("unable to parse URL %s: %w", url, err))
+			continue
+		}
+		url = bu
+
+		// Give each URL its own context so it can be aborted by the circuit breaker or the host timeout (releasing
+		// the previous one)
+		var ctx context.Context
+		var abort context.CancelFunc
+		if s.opts.HostTimeout > 0 {
+			root := url
+			for p, ok := parents[root]; ok; p, ok = parents[root] {
+				root = p
+			}
+			if _, ok := started[root]; !ok {
+				started[root] = time.Now()
+			}
+			ctx, abort = context.WithDeadline(sctx, started[root].Add(s.opts.HostTimeout))
+		} else {
+			ctx, abort = context.WithCancel(sctx)
+		}
+		st.Lock()
+		if st.abortHost != nil {
+			st.abortHost()
+		}
+		st.abortHost, st.window, st.windowPos, st.windowErrors = abort, nil, 0, 0
+		st.Unlock()
+
+		// Grab some headers and make sure the URL is accessible
+		res, _, err := s.fetch(ctx, st, "GET", url+".aspx")
+		if sctx.Err() != nil {
+			break
+		} else if ctx.Err() != nil {
+			continue
+		} else if err != nil {
+
+			// Note the URL as unreachable and move on to the next one
+			log.WithFields(log.Fields{"url": url, "error": err}).Warn("Unable to access server, skipping")
+			so := statusOutput{Type: "status", Version: version, Url: url, Unreachable: true}
+			s.printJSON(so)
+			if s.onStatus != nil {
+				s.onStatus(so)
+			}
+			rt.Lock()
+			rt.statuses = append(rt.statuses, so)
+			rt.Unlock()
+			if _, ok := parents[url]; !ok {
+				errs = append(errs, fmt.Errorf("unable to access %s: %w", url, err))
+			}
+			continue
+		}
+
+		// Open connections and check the server's latency against the timeout if requested (once per URL given)
+		if _, ok := parents[url]; s.opts.Warmup && !ok {
+			s.warmup(ctx, st, url)
+		}
+
+		// Display server information
+		s.printHuman("\n════════════════════════════════════════════════════════════════════════════════")
+		s.printHuman(color.New(color.FgWhite, color.Bold).Sprint("URL")+":", url)
+		srv := "<unknown>"
+		if len(res.Header["Server"]) > 0 {
+			srv = strings.Join(res.Header["Server"], ", ")
+		}
+		if v, ok := res.Header["X-Aspnet-Version"]; ok {
+			srv += " (ASP.NET v" + v[0] + ")"
+		}
+		if s.opts.Output == "human" && srv != "<unknown>" && !strings.Contains(srv, "IIS") && !strings.Contains(srv, "ASP") {
+			srv += " " + color.HiRedString("[!]")
+		}
+		s.printHuman(color.New(color.FgWhite, color.Bold).Sprint("Running")+":", srv)
+
+		// Check how the server responds to a valid URL with an invalid HTTP method (for autoselecting autocomplete and
+		// fingerprinting)
+		var badMethod int
+		if s.opts.Autocomplete == "auto" || s.opts.Fingerprint {
+			if res, _, err := s.fetch(ctx, st, "_", url); err == nil {
+				badMethod = res.StatusCode
+			}
+		}
+
+		// If autocomplete is in autoselect mode
+		mode := s.opts.Autocomplete
+		if mode == "auto" {
+
+			// A 405 Method Not Allowed can be used as a reliable method to detecting whether file candidates exist
+			if badMethod == 405 {
+				mode = "method"
+				log.Info("Using method-based file existence checks")
+			} else {
+				mode = "status"
+				log.Info("Using status-based file existence checks")
+			}
+
+		}
+
+		// ---------------------------------------------------
+		// First stage: check whether the server is vulnerable
+		// ---------------------------------------------------
+
+		// Let JSON consumers know which stage the scan is at
+		s.printJSON(eventOutput{Type: "event", Version: version, Event: "detection", Url: url})
+
+		// Initialise attack config
+		ac := attackConfig{wordlist: s.wordlist, autocomplete: mode, rng: s.newRand(url)}
+
+		// Status checks can't tell files apart on a server that returns a 200 for everything (a soft 404), so
+		// switch to distance checks if autocomplete was autoselected, otherwise suggest it
+		if mode == "status" && s.isSoft404(ctx, st, &ac, url) {
+			if s.opts.Autocomplete == "auto" {
+				ac.autocomplete = "distance"
+				log.WithFields(log.Fields{"url": url}).Warn("Server returns 200 for non-existent files (soft 404), using distance-based file existence checks")
+			} else {
+				log.WithFields(log.Fields{"url": url}).Warn("Server returns 200 for non-existent files (soft 404), status-based autocomplete is unlikely to work (try -a distance)")
+			}
+		}
+
+		// Just autocomplete the given short names if there are any
+		if len(s.shortNames) > 0 {
+			s.printHuman(color.New(color.FgWhite, color.Bold).Sprint("Short names:"), len(s.shortNames), "given, skipping detection")
+			s.printHuman("════════════════════════════════════════════════════════════════════════════════")
+			s.resolveShortNames(ctx, st, &ac, url, parents[url])
+			s.flushResults(&ac, url, mk, rt)
+			s.printJSON(getSummary(ctx, url, &ac))
+			s.printJSON(eventOutput{Type: "event", Version: version, Event: "complete", Url: url})
+			s.printHuman("════════════════════════════════════════════════════════════════════════════════")
+			continue
+		}
+
+		// Determine how many methods to try
+		var pc, mc int
+		if s.opts.Patience >= 1 {
+			pc = len(pathSuffixes)
+			mc = len(httpMethods)
+		} else {
+			pc = 4
+			mc = 9
+		}
+
+		// Determine how many negative samples to take
+		ns := 4
+		if s.opts.Patience >= 1 {
+			ns = 8
+		}
+		if s.opts.NegSamples > 0 {
+			ns = s.opts.NegSamples
+		}
+
+		// Pick the suffixes and methods to try, shuffling the order if requested
+		suffixes := append([]string(nil), pathSuffixes[:pc]...)
+		methods := append([]string(nil), httpMethods[:mc]...)
+		if s.opts.Randomise {
+			ac.rng.Shuffle(len(suffixes), func(i, j int) { suffixes[i], suffixes[j] = suffixes[j], suffixes[i] })
+			ac.rng.Shuffle(len(methods), func(i, j int) { methods[i], methods[j] = methods[j], methods[i] })
+		}
+
+		// Pick the wildcard styles to try, each with every suffix
+		styles := []string{s.opts.WildcardStyle}
+		if s.opts.WildcardStyle == "auto" {
+			styles = []string{"star", "dos"}
+		}
+		type detectionProbe struct {
+			suffix string
+			wc     wildcards
+		}
+		var probes []detectionProbe
+		for _, ws := range styles {
+			for _, suffix := range suffixes {
+				probes = append(probes, detectionProbe{suffix, s.wildcards(ws)})
+			}
+		}
+		ac.wc = s.wildcards(styles[0])
+
+		// Use the given markers instead of detecting them if they were all provided
+		if s.opts.Method != "" {
+			probes, methods = nil, nil
+			if ts := s.confirmMarkers(ctx, st, url, s.opts.Method, *s.opts.Suffix, ac.wc, s.opts.StatusPos, s.opts.StatusNeg); len(ts) > 0 {
+				ac.tildes, ac.method, ac.suffix = ts, s.opts.Method, *s.opts.Suffix
+				mk.statusPos, mk.statusNeg = s.opts.StatusPos, s.opts.StatusNeg
+			}
+		}
+
+		// Loop through path suffixes (and wildcard styles)
+		outerEscape:
+		for _, dp := range probes {
+			suffix, wc := dp.suffix, dp.wc
+
+			// Loop through each method
+			methodEscape:
+			for _, method := range methods {
+
+				// Make some requests for non-existent files
+				var statuses []int
+				validMarkers := struct{ status bool }{true}
+				for i := 0; i < ns; i++ {
+
+					// Fetch a "bad" URL (tildes >= ~5 will never be created on Windows 2000 upwards)
+					res, _, err := s.fetch(ctx, st, method, fmt.Sprintf("%s%s%d%s%s", url, wc.star, ac.rng.Intn(5)+5, wc.star, suffix))
+
+					// Skip this method if all requests failed
+					if err != nil {
+						log.Debug("Method " + method + " failed, skipping")
+						continue methodEscape
+					}
+
+					// Store the response status code
+					statuses = append(statuses, res.StatusCode)
+
+				}
+
+				// Skip this method if the negative status code wasn't stable enough
+				statusNeg, dist, ok := negativeStatus(statuses, s.opts.NegThreshold)
+				log.WithFields(log.Fields{"method": method, "suffix": suffix, "statuses": dist, "statusNeg": statusNeg}).Debug("Negative status distribution")
+				if !ok {
+					log.WithFields(log.Fields{"statuses": dist}).Debug("Method " + method + " unstable, skipping")
+					continue methodEscape
+				}
+
+				// If there's at least one usable marker
+				if validMarkers.status {
+
+					// Request available 8.3 files
+					for i := 1; i <= 4; i++ {
+
+						// Fetch the URL and check whether it looks like a hit
+						res, _, err := s.fetch(ctx, st, method, fmt.Sprintf("%s%s~%d%s%s", url, wc.star, i, wc.star, suffix))
+						if err == nil {
+
+							// Hit response status code
+							statusPos := res.StatusCode
+
+							// If this could be a hit
+							if validMarkers.status && statusPos != statusNeg {
+
+								// Fetch a "bad" URL and check the status doesn't match the status code we just got
+								res, _, err := s.fetch(ctx, st, method, fmt.Sprintf("%s%s~0%s%s", url, wc.star, wc.star, suffix))
+								if err != nil || statusPos == res.StatusCode {
+
+									// Could be rate limiting (...or we could have killed the server)
+									log.WithFields(log.Fields{"statusPos": statusPos, "statusNeg": statusNeg}).Debug("Negative response differed, could be rate limiting or server instability")
+
+								} else {
+
+									// Update tilde list and status marker
+									ac.tildes = append(ac.tildes, fmt.Sprintf("~%d", i))
+									mk.statusPos = statusPos
+									mk.statusNeg = statusNeg
+
+								}
+
+							}
+
+						}
+
+					}
+
+					// If 8.3 files were found
+					if len(ac.tildes) > 0 {
+						ac.method = method
+						ac.suffix = suffix
+						ac.wc = wc
+						break outerEscape
+					}
+
+				}
+
+			}
+
+		}
+
+		// Don't report on a half-finished detection stage
+		if sctx.Err() != nil {
+			break
+		} else if ctx.Err() != nil {
+			s.printJSON(getSummary(ctx, url, &ac))
+			s.printJSON(eventOutput{Type: "event", Version: version, Event: "complete", Url: url})
+			continue
+		}
+
+		// Output JSON status if requested
+		so := statusOutput{Type: "status", Version: version, Url: url, Server: srv, Vulnerable: len(ac.tildes) > 0}
+		if s.opts.Fingerprint {
+			so.IISVersion, so.IISEvidence = fingerprintIIS(res.Header, badMethod)
+			if so.IISVersion != "" {
+				s.printHuman(color.New(color.FgWhite, color.Bold).Sprint("Likely IIS:"), so.IISVersion, color.HiBlackString("("+strings.Join(so.IISEvidence, "; ")+")"))
+			}
+		}
+		s.printJSON(so)
+		if s.onStatus != nil {
+			s.onStatus(so)
+		}
+		rt.Lock()
+		rt.statuses = append(rt.statuses, so)
+		rt.Unlock()
+
+		// Skip this URL if no tilde files could be identified :'(
+		if len(ac.tildes) == 0 {
+			s.printHuman(color.New(color.FgWhite, color.Bold).Sprint("Vulnerable:"), color.HiBlueString("No"), "(or no 8.3 files exist)")
+			s.printHuman("════════════════════════════════════════════════════════════════════════════════")
+			s.printJSON(getSummary(ctx, url, &ac))
+			s.printJSON(eventOutput{Type: "event", Version: version, Event: "complete", Url: url})
+			continue
+		}
+
+		// We are GO for second stage
+		s.printHuman(color.New(color.FgWhite, color.Bold).Sprint("Vulnerable:"), color.HiRedString("Yes!"))
+		s.printHuman("════════════════════════════════════════════════════════════════════════════════")
+		log.WithFields(log.Fields{"method": ac.method, "suffix": ac.suffix, "wildcards": ac.wc.star + " " + ac.wc.qmark, "statusPos": mk.statusPos, "statusNeg": mk.statusNeg}).Info("Found working options")
+		log.WithFields(log.Fields{"tildes": ac.tildes}).Info("Found tilde files")
+
+		// Bail here if we're just running a vuln check
+		if s.opts.IsVuln {
+			s.printJSON(getSummary(ctx, url, &ac))
+			s.printJSON(eventOutput{Type: "event", Version: version, Event: "complete", Url: url})
+			continue
+		}
+
+		// --------------------------------------------------
+		// Second stage: find out which characters are in use
+		// --------------------------------------------------
+
+		// Let JSON consumers know which stage the scan is at
+		s.printJSON(eventOutput{Type: "event", Version: version, Event: "charset", Url: url})
+
+		// Note request counts so the health of the enumeration can be checked afterwards
+		st.Lock()
+		r0, y0, f0 := st.requests, st.retries, st.failures
+		st.Unlock()
+
+		// Reuse the character set already discovered on this host if requested and it covers all the tilde levels
+		reused := false
+		host := url
+		if u, err := nurl.Parse(url); err == nil {
+			host = u.Scheme + "://" + u.Host
+		}
+		if cs, ok := charsets[host]; ok && s.opts.ReuseCharset && coversTildes(cs, ac.tildes) {
+			ac.fileChars, ac.extChars = cs.fileChars, cs.extChars
+			reused = true
+			log.WithFields(log.Fields{"host": host, "fileChars": ac.fileChars, "extChars": ac.extChars}).Info("Reusing character set")
+		} else {
+			s.getCharacters(ctx, st, &ac, mk, url)
+			charsets[host] = charset{ac.fileChars, ac.extChars}
+		}
+
+		// --------------------------------------
+		// Third stage: enumerate all the things!
+		// --------------------------------------
+
+		// Let JSON consumers know which stage the scan is at
+		s.printJSON(eventOutput{Type: "event", Version: version, Event: "enumeration", Url: url})
+
+		// Initialise things
+		ac.foundFiles = make(map[string]struct{})
+		ac.stems = make(map[string]map[string]struct{})
+		ac.statusCache = make(map[string]*statusSample)
+		ac.distanceCache = make(map[string]*distanceSample)
+		sem := newLimiter(s.opts.Concurrency, s.opts.Adaptive)
+		wg := new(sync.WaitGroup)
+
+		// Loop through the tilde pool
+		for _, tilde := range ac.tildes {
+			s.enumerateTilde(ctx, sem, wg, st, &ac, mk, baseRequest{url: url, parent: parents[url], file: "", tilde: tilde, ext: ""})
+		}
+		wg.Wait()
+
+		// If the reused character set turned up nothing, probe the characters afresh and try again
+		if reused && ac.fileCount+ac.dirCount+ac.partialCount == 0 && ctx.Err() == nil {
+			log.WithFields(log.Fields{"url": url}).Info("Nothing found with the reused character set, probing characters again")
+			s.getCharacters(ctx, st, &ac, mk, url)
+			charsets[host] = charset{ac.fileChars, ac.extChars}
+			for _, tilde := range ac.tildes {
+				s.enumerateTilde(ctx, sem, wg, st, &ac, mk, baseRequest{url: url, parent: parents[url], file: "", tilde: tilde, ext: ""})
+			}
+			wg.Wait()
+		}
+
+		// Warn if it looks like enumeration finished before it should have
+		st.Lock()
+		r, y, f := st.requests-r0, st.retries-y0, st.failures-f0
+		st.Unlock()
+		if rs := earlyTermination(&ac, r, y, f); len(rs) > 0 && ctx.Err() == nil {
+			log.WithFields(log.Fields{"url": url, "reasons": rs}).Warn("Enumeration may have finished early")
+			s.printHuman(color.HiYellowString("[!] Enumeration may have finished early: " + strings.Join(rs, "; ")))
+			s.printHuman(color.HiYellowString("[!] Consider using --stabilise, a lower --concurrency, or a higher --patience"))
+		}
+
+		// Output the results
+		s.flushResults(&ac, url, mk, rt)
+
+		// Warn if the host timeout cut this URL short
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && sctx.Err() == nil {
+			log.WithFields(log.Fields{"url": url, "timeout": s.opts.HostTimeout}).Warn("Host timeout exceeded, results are partial")
+		}
+
+		// Prepend discovered directories for processing next iteration (unless the host was aborted)
+		fd := ac.directories()
+		for i := len(fd) - 1; i >= 0 && ctx.Err() == nil; i-- {
+			d := url + fd[i] + "/"
+			parents[d] = url
+			urls = append([]string{d}, urls...)
+		}
+
+		// Output the JSON summary for this URL if requested
+		s.printJSON(getSummary(ctx, url, &ac))
+		s.printJSON(eventOutput{Type: "event", Version: version, Event: "complete", Url: url})
+
+		// <hr>
+		s.printHuman("════════════════════════════════════════════════════════════════════════════════")
+
+	}
+
+	// Release the last URL's context
+	st.Lock()
+	if st.abortHost != nil {
+		st.abortHost()
+	}
+	st.Unlock()
+
+	return len(urls), errs
+
+}
+
+// loadWordlist reads a wordlist or rainbow table into the wordlist config (the rainbow table magic value is
+// checked per file, so plain wordlists and rainbow tables can be mixed)
+func loadWordlist(wc *wordlistConfig, sc *bufio.Scanner, name string, strict bool) error {
+
+	// Read the wordlist into memory
+	n, ln := 0, 0
+	isRainbow := false
+	for sc.Scan() {
+
+		// Read the line
+		line := sc.Text()
+		ln++
+
+		// Check the first line for the rainbow table magic value
+		if n == 0 && line == rainbowMagic {
+			isRainbow, wc.isRainbow = true, true
+			log.Info("Rainbow table provided, enabling auto dechecksumming")
+			continue
+		}
+
+		// Skip blank lines and comments
+		if l := len(line); l == 0 || line[0] == '#' {
+			continue
+		}
+
+		// Add the line to the wordlist
+		if isRainbow {
+
+			// Check tab count (with an optional priority column), skipping invalid entries unless a strict wordlist is required
+			if t := strings.Count(line, "\t"); t != 4 && t != 5 {
+				if strict {
+					return fmt.Errorf("wordlist entry invalid (incorrect tab count) on line %d of %s", ln, name)
+				}
+				log.WithFields(log.Fields{"file": name, "number": ln, "line": line}).Warn("Wordlist entry invalid (incorrect tab count), skipping")
+				continue
+			}
+
+			// Split the line and read the priority if there is one
+			c := strings.Split(line, "\t")
+			var pr int
+			if len(c) == 6 {
+				var err error
+				if pr, err = strconv.Atoi(c[5]); err != nil {
+					if strict {
+						return fmt.Errorf("wordlist entry invalid (bad priority) on line %d of %s", ln, name)
+					}
+					log.WithFields(log.Fields{"file": name, "number": ln, "line": line}).Warn("Wordlist entry invalid (bad priority), skipping")
+					continue
+				}
+			}
+
+			// Add the word
+			f, e, f83, e83 := c[3], c[4], c[1], c[2]
+			if len(e) > 0 {
+				e = "." + e
+			}
+			wc.wordlist = append(wc.wordlist, wordlistRecord{c[0], f, e, f83, e83, pr})
+
+		} else {
+
+			// Split off the priority if there is one (word, tab, priority)
+			var pr int
+			if p := strings.LastIndex(line, "\t"); p >= 0 {
+				var err error
+				if pr, err = strconv.Atoi(line[p+1:]); err != nil {
+					if strict {
+						return fmt.Errorf("wordlist entry invalid (bad priority) on line %d of %s", ln, name)
+					}
+					log.WithFields(log.Fields{"file": name, "number": ln, "line": line}).Warn("Wordlist entry invalid (bad priority), skipping")
+					continue
+				}
+				line = line[:p]
+			}
+
+			// Split the line into file and extension and generate an 8.3 version
+			var r wordlistRecord
+			if p := strings.LastIndex(line, "."); p > 0 && line[0] != '.' {
+				f, e := line[:p], line[p:]
+				_, f83, e83 := shortutil.Gen8dot3(f, e)
+				r = wordlistRecord{"", f, e, f83, e83, pr}
+			} else {
+				_, f83, _ := shortutil.Gen8dot3(line, "")
+				r = wordlistRecord{"", line, "", f83, "", pr}
+			}
+			wc.wordlist = append(wc.wordlist, r)
+
+		}
+
+		// Next
+		n += 1
 
-	// Loop through each URL
-	for len(urls) > 0 {
+	}
 
-		// Pop off a URL
-		var url string
-		url, urls = urls[0], urls[1:]
-		url = strings.TrimSuffix(url, "/") + "/"
+	return sc.Err()
 
-		// Default to HTTPS if no protocol was supplied
-		if !strings.Contains(url, "://") {
-			url = "https://" + url
-		}
+}
 
-		// -----------------------------------------------
-		// Pre-flight: check that the server is accessible
-		// -----------------------------------------------
+// Wordlist is a wordlist (or rainbow table) loaded for autocompleting short names offline
+type Wordlist struct {
+	wc *wordlistConfig
+}
 
-		// Validate the URL
-		if _, err := nurl.Parse(url); err != nil {
-			log.WithFields(log.Fields{"url": url, "error": err}).Fatal("Unable to parse URL")
-		}
+// LoadWordlist reads a wordlist or rainbow table from a file for use with Candidates (an empty path loads the
+// built-in wordlist)
+func LoadWordlist(path string) (*Wordlist, error) {
 
-		// Grab some headers and make sure the URL is accessible
-		res, err := fetch(hc, st, "GET", url+".aspx")
+	// Open the wordlist
+	var fh io.ReadCloser
+	name := path
+	if path == "" {
+		fh, _ = defaultWordlist.Open("resources/wordlist.txt")
+		name = "built-in"
+	} else {
+		f, err := os.Open(path)
 		if err != nil {
-			log.WithFields(log.Fields{"error": err}).Fatal("Unable to access server")
+			return nil, fmt.Errorf("unable to open wordlist: %w", err)
 		}
+		fh = f
+	}
+	defer fh.Close()
 
-		// Display server information
-		printHuman("\n════════════════════════════════════════════════════════════════════════════════")
-		printHuman(color.New(color.FgWhite, color.Bold).Sprint("URL")+":", url)
-		srv := "<unknown>"
-		if len(res.Header["Server"]) > 0 {
-			srv = strings.Join(res.Header["Server"], ", ")
-		}
-		if v, ok := res.Header["X-Aspnet-Version"]; ok {
-			srv += " (ASP.NET v" + v[0] + ")"
-		}
-		if args.Output == "human" && srv != "<unknown>" && !strings.Contains(srv, "IIS") && !strings.Contains(srv, "ASP") {
-			srv += " " + color.HiRedString("[!]")
-		}
-		printHuman(color.New(color.FgWhite, color.Bold).Sprint("Running")+":", srv)
+	// Load and index it
+	wc := &wordlistConfig{}
+	if err := loadWordlist(wc, bufio.NewScanner(fh), name, false); err != nil {
+		return nil, err
+	}
+	indexWordlist(wc)
 
-		// If autocomplete is in autoselect mode
-		if args.Autocomplete == "auto" {
+	return &Wordlist{wc}, nil
 
-			// Check whether requesting a valid URL with an invalid HTTP method returns a 405 Method Not Allowed,
-			// which autocomplete can use as a reliable method to detecting whether file candidates exist
-			if res, err := fetch(hc, st, "_", url); err == nil && res.StatusCode == 405 {
-				args.Autocomplete = "method"
-				log.Info("Using method-based file existence checks")
-			} else {
-				args.Autocomplete = "status"
-				log.Info("Using status-based file existence checks")
-			}
+}
 
+// Candidates returns the full filenames the wordlist suggests for a short name such as DEFAUL~1.ASP, most likely
+// first, matching them the same way a scan does but without making any requests
+func (w *Wordlist) Candidates(shortName string) ([]string, error) {
+
+	// Split up the short name
+	m := shortNameRegex.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(shortName)))
+	if m == nil {
+		return nil, fmt.Errorf("not an 8.3 short name: %s", shortName)
+	}
+	br := baseRequest{file: m[1], tilde: m[2], ext: m[3]}
+
+	// Match it against the wordlist, dropping any duplicates between dechecksummed and plain candidates
+	var cs []string
+	seen := make(map[string]struct{})
+	for _, c := range wordlistCandidates(&attackConfig{wordlist: w.wc}, br) {
+		if _, ok := seen[c.filename+c.extension]; !ok {
+			seen[c.filename+c.extension] = struct{}{}
+			cs = append(cs, c.filename+c.extension)
 		}
+	}
 
-		// ---------------------------------------------------
-		// First stage: check whether the server is vulnerable
-		// ---------------------------------------------------
+	return cs, nil
 
-		// Initialise attack config
-		ac := attackConfig{wordlist: wc}
+}
 
-		// Determine how many methods to try
-		var pc, mc int
-		if args.Patience == 1 {
-			pc = len(pathSuffixes)
-			mc = len(httpMethods)
-		} else {
-			pc = 4
-			mc = 9
+// indexWordlist builds lookup tables (by 8.3 stem and by each checksum) so that autocomplete and dechecksumming don't have to walk the whole wordlist for each discovery
+func indexWordlist(wc *wordlistConfig) {
+	wc.byStem = make(map[string][]wordlistRecord)
+	wc.byChecksum = make(map[string][]wordlistRecord)
+	for _, r := range wc.wordlist {
+		wc.byStem[r.filename83] = append(wc.byStem[r.filename83], r)
+		for i := 0; i+4 <= len(r.checksums); i += 4 {
+			wc.byChecksum[r.checksums[i:i+4]] = append(wc.byChecksum[r.checksums[i:i+4]], r)
 		}
+	}
+}
 
-		// Loop through path suffixes
-		outerEscape:
-		for _, suffix := range pathSuffixes[:pc] {
-
-			// Loop through each method
-			methodEscape:
-			for _, method := range httpMethods[:mc] {
-
-				// Make some requests for non-existent files
-				var statusNeg int
-				validMarkers := struct{ status bool }{true}
-				for i := 0; i < 4; i++ {
+// reportWordlist logs (and optionally outputs) coverage statistics for the loaded wordlist
+func (s *Scanner) reportWordlist() {
 
-					// Fetch a "bad" URL (tildes >= ~5 will never be created on Windows 2000 upwards)
-					res, err := fetch(hc, st, method, fmt.Sprintf("%s*%d*%s", url, rand.Intn(5)+5, suffix))
+	wc := s.wordlist
 
-					// Skip this method if all requests failed
-					if err != nil {
-						log.Debug("Method " + method + " failed, skipping")
-						continue methodEscape
-					}
+	// Count unique stems, checksummed entries, and extensions
+	stems := make(map[string]struct{})
+	exts := make(map[string]int)
+	cs := 0
+	for _, r := range wc.wordlist {
+		stems[r.filename83] = struct{}{}
+		exts[strings.ToLower(r.extension)]++
+		if r.checksums != "" {
+			cs++
+		}
+	}
 
-					// Response status code
-					status := res.StatusCode
+	// Find the most common extensions
+	el := make([]string, 0, len(exts))
+	for e := range exts {
+		el = append(el, e)
+	}
+	sort.Slice(el, func(i, j int) bool {
+		if exts[el[i]] != exts[el[j]] {
+			return exts[el[i]] > exts[el[j]]
+		}
+		return el[i] < el[j]
+	})
+	top := make(map[string]int)
+	var ts []string
+	for _, e := range el[:maths.Min(len(el), 10)] {
+		top[e] = exts[e]
+		n := e
+		if n == "" {
+			n = "<none>"
+		}
+		ts = append(ts, fmt.Sprintf("%s (%d)", n, exts[e]))
+	}
 
-					// Skip this method if the same status code wasn't received for every request
-					if statusNeg != 0 && status != statusNeg {
-						log.WithFields(log.Fields{"status": status, "statusNeg": statusNeg}).Debug("Method " + method + " unstable, skipping")
-						continue methodEscape
-					}
+	// Logging
+	log.WithFields(log.Fields{"entries": len(wc.wordlist), "stems": len(stems), "checksummed": cs, "extensions": top}).Info("Wordlist statistics")
 
-					// Store the negative response status code
-					statusNeg = status
+	// Output the statistics if requested
+	if s.opts.WordlistStats {
+		s.printHuman(fmt.Sprintf("%s %d entries; %d unique 8.3 stems; %d with checksums", color.New(color.FgWhite, color.Bold).Sprint("Wordlist:"), len(wc.wordlist), len(stems), cs))
+		s.printHuman(fmt.Sprintf("%s %s", color.New(color.FgWhite, color.Bold).Sprint("Top extensions:"), strings.Join(ts, ", ")))
+		s.printJSON(wordlistOutput{Type: "wordlist", Version: version, Entries: len(wc.wordlist), Stems: len(stems), Checksummed: cs, Extensions: top})
+	}
 
-				}
+}
 
-				// If there's at least one usable marker
-				if validMarkers.status {
+// expandCidr returns the host addresses in an IPv4 CIDR range (excluding the network and broadcast addresses)
+func expandCidr(cidr string) ([]string, error) {
 
-					// Request available 8.3 files
-					for i := 1; i <= 4; i++ {
+	// Parse the range
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	ip := n.IP.To4()
+	if ip == nil {
+		return nil, fmt.Errorf("only IPv4 CIDR ranges are supported: %s", cidr)
+	}
 
-						// Fetch the URL and check whether it looks like a hit
-						res, err := fetch(hc, st, method, fmt.Sprintf("%s*~%d*%s", url, i, suffix))
-						if err == nil {
+	// Check the size of the range
+	ones, bits := n.Mask.Size()
+	size := 1 << (bits - ones)
+	if size > maxCidrHosts {
+		return nil, fmt.Errorf("CIDR range %s is too large (maximum %d addresses)", cidr, maxCidrHosts)
+	} else if size > 1024 {
+		log.WithFields(log.Fields{"cidr": cidr, "addresses": size}).Warn("Scanning a large CIDR range")
+	}
 
-							// Hit response status code
-							statusPos := res.StatusCode
+	// Build the list of hosts
+	var hs []string
+	start := uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+	for i := 0; i < size; i++ {
+		if size > 2 && (i == 0 || i == size-1) {
+			continue
+		}
+		a := start + uint32(i)
+		hs = append(hs, net.IPv4(byte(a>>24), byte(a>>16), byte(a>>8), byte(a)).String())
+	}
 
-							// If this could be a hit
-							if validMarkers.status && statusPos != statusNeg {
+	return hs, nil
 
-								// Fetch a "bad" URL and check the status doesn't match the status code we just got
-								res, _ := fetch(hc, st, method, fmt.Sprintf("%s*~0*%s", url, suffix))
-								if statusPos == res.StatusCode {
+}
 
-									// Could be rate limiting (...or we could have killed the server)
-									log.WithFields(log.Fields{"statusPos": statusPos, "statusNeg": statusNeg}).Debug("Negative response differed, could be rate limiting or server instability")
+// readHeaders reads headers in "Name: Value" form from a file, skipping blank lines and comments
+func readHeaders(path string) ([]string, error) {
 
-								} else {
+	// Open the file
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open headers file: %s", err)
+	}
+	defer fh.Close()
+
+	// Read headers line by line
+	var hs []string
+	sc := bufio.NewScanner(fh)
+	for n := 1; sc.Scan(); n++ {
+		l := strings.TrimSpace(sc.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		if !strings.Contains(l, ":") {
+			return nil, fmt.Errorf("invalid header on line %d of %s: %s", n, path, l)
+		}
+		hs = append(hs, l)
+	}
 
-									// Update tilde list and status marker
-									ac.tildes = append(ac.tildes, fmt.Sprintf("~%d", i))
-									mk.statusPos = statusPos
-									mk.statusNeg = statusNeg
+	return hs, sc.Err()
 
-								}
+}
 
-							}
+// mergeHeaders combines headers from a file with those given on the command line, dropping any file
+// headers that are also set on the command line so that the command line takes precedence
+func mergeHeaders(file []string, flags []string) []string {
 
-						}
+	// Note which headers were set on the command line
+	set := make(map[string]struct{})
+	for _, h := range flags {
+		set[strings.ToLower(strings.TrimSpace(strings.SplitN(h, ":", 2)[0]))] = struct{}{}
+	}
 
-					}
+	// Keep file headers that weren't overridden
+	var hs []string
+	for _, h := range file {
+		if _, ok := set[strings.ToLower(strings.TrimSpace(strings.SplitN(h, ":", 2)[0]))]; !ok {
+			hs = append(hs, h)
+		}
+	}
 
-					// If 8.3 files were found
-					if len(ac.tildes) > 0 {
-						ac.method = method
-						ac.suffix = suffix
-						break outerEscape
-					}
+	return append(hs, flags...)
 
-				}
+}
 
-			}
+// readRequest parses a raw HTTP/1.x request from a file, returning the base URL it was made to and its headers
+func readRequest(path string) (string, []string, error) {
 
-		}
+	// Open and parse the request
+	fh, err := os.Open(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to open request file: %s", err)
+	}
+	defer fh.Close()
+	req, err := http.ReadRequest(bufio.NewReader(fh))
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to parse request file: %s", err)
+	}
 
-		// Output JSON status if requested
-		printJSON(statusOutput{Type: "status", Url: url, Server: srv, Vulnerable: len(ac.tildes) > 0})
+	// Build the target URL from the request (the directory containing the requested resource)
+	u := *req.URL
+	if u.Scheme == "" {
+		u.Scheme = "https"
+	}
+	if u.Host == "" {
+		u.Host = req.Host
+	}
+	u.Path = u.Path[:strings.LastIndex(u.Path, "/")+1]
+	u.RawPath, u.RawQuery, u.Fragment = "", "", ""
 
-		// Skip this URL if no tilde files could be identified :'(
-		if len(ac.tildes) == 0 {
-			printHuman(color.New(color.FgWhite, color.Bold).Sprint("Vulnerable:"), color.HiBlueString("No"), "(or no 8.3 files exist)")
-			printHuman("════════════════════════════════════════════════════════════════════════════════")
+	// Collect the headers, skipping those that are tied to the original connection or body
+	var hs []string
+	if req.Host != "" {
+		hs = append(hs, "Host: "+req.Host)
+	}
+	var ns []string
+	for n := range req.Header {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	for _, n := range ns {
+		switch n {
+		case "Connection", "Content-Length", "Transfer-Encoding", "Accept-Encoding", "Keep-Alive", "Upgrade":
 			continue
 		}
-
-		// We are GO for second stage
-		printHuman(color.New(color.FgWhite, color.Bold).Sprint("Vulnerable:"), color.HiRedString("Yes!"))
-		printHuman("════════════════════════════════════════════════════════════════════════════════")
-		log.WithFields(log.Fields{"method": ac.method, "suffix": ac.suffix, "statusPos": mk.statusPos, "statusNeg": mk.statusNeg}).Info("Found working options")
-		log.WithFields(log.Fields{"tildes": ac.tildes}).Info("Found tilde files")
-
-		// Bail here if we're just running a vuln check
-		if args.IsVuln {
-			continue
+		for _, v := range req.Header[n] {
+			hs = append(hs, n+": "+v)
 		}
+	}
 
-		// --------------------------------------------------
-		// Second stage: find out which characters are in use
-		// --------------------------------------------------
-
-		// Loop twice, first to check file characters, then to check extension characters
-		ac.fileChars, ac.extChars = make(map[string]string), make(map[string]string)
-		for i := 0; i < 2; i++ {
+	return u.String(), hs, nil
 
-			// Loop through characters and tilde levels
-			for _, char := range args.Characters {
-				for _, tilde := range ac.tildes {
+}
 
-					// Set the check URL and character map
-					var cu string
-					var cm map[string]string
-					if i == 0 {
-						cm = ac.fileChars
-						cu = url + "*" + pathEscape(string(char)) + "*" + tilde + "*" + ac.suffix
-					} else {
-						cm = ac.extChars
-						cu = url + "*" + tilde + "*" + pathEscape(string(char)) + "*" + ac.suffix
-					}
+// getTransport builds the HTTP transport for the given options, routing requests through a proxy if one was specified
+func getTransport(opts Options) (*http.Transport, error) {
 
-					// Add hits to the character map
-					res, err := fetch(hc, st, ac.method, cu)
-					if err == nil && res.StatusCode != mk.statusNeg {
-						cm[tilde] = cm[tilde] + string(char)
-					}
+	// Base transport
+	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Renegotiation: tls.RenegotiateOnceAsClient}, Proxy: http.ProxyFromEnvironment}
 
-				}
-			}
+	// Verify certificates against a specific CA if one was given (only that CA is trusted, so with an intercepting
+	// proxy any connection that isn't being intercepted will fail verification rather than being silently accepted)
+	if opts.CaCert != "" {
+		pem, err := os.ReadFile(opts.CaCert)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA certificate: %s", err)
 		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", opts.CaCert)
+		}
+		tr.TLSClientConfig.RootCAs = pool
+		tr.TLSClientConfig.InsecureSkipVerify = false
+	}
 
-		// Status
-		log.WithFields(log.Fields{"fileChars": ac.fileChars, "extChars": ac.extChars}).Info("Built character set")
-
-		// --------------------------------------
-		// Third stage: enumerate all the things!
-		// --------------------------------------
+	// No explicit proxy
+	if opts.Proxy == "" {
+		return tr, nil
+	}
 
-		// Initialise things
-		ac.foundFiles = make(map[string]struct{})
-		sem := make(chan struct{}, args.Concurrency)
-		wg := new(sync.WaitGroup)
+	// Parse the proxy URL
+	pu, err := nurl.Parse(opts.Proxy)
+	if err != nil || pu.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL: %s", opts.Proxy)
+	}
 
-		// Loop through the tilde pool
-		for _, tilde := range ac.tildes {
-			enumerate(sem, wg, hc, st, &ac, mk, baseRequest{url: url, file: "", tilde: tilde, ext: ""})
+	// Set up the proxy (TLS to the target still happens inside the tunnel, so TLS settings apply as normal)
+	switch pu.Scheme {
+	case "http", "https":
+		tr.Proxy = http.ProxyURL(pu)
+	case "socks5", "socks5h":
+		d, err := proxy.FromURL(pu, &net.Dialer{Timeout: time.Duration(opts.Timeout) * time.Second})
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %s", err)
 		}
-		wg.Wait()
-
-		// Prepend discovered directories for processing next iteration
-		for i := len(ac.foundDirectories) - 1; i >= 0; i-- {
-			urls = append([]string{url + ac.foundDirectories[i] + "/"}, urls...)
+		cd, ok := d.(proxy.ContextDialer)
+		if !ok {
+			return nil, fmt.Errorf("proxy dialer doesn't support contexts")
 		}
+		tr.Proxy = nil
+		tr.DialContext = cd.DialContext
+	default:
+		return nil, fmt.Errorf("proxy scheme must be one of: http, https, socks5")
+	}
 
-		// <hr>
-		printHuman("════════════════════════════════════════════════════════════════════════════════")
+	return tr, nil
+
+}
+
+// writeHeapProfile writes a heap profile to the given file, logging rather than failing if it can't
+func writeHeapProfile(path string) {
 
+	fh, err := os.Create(path)
+	if err != nil {
+		log.WithFields(log.Fields{"file": path, "err": err}).Error("Unable to create heap profile")
+		return
 	}
-	printHuman()
+	defer fh.Close()
 
-	// Fin
-	printHuman(fmt.Sprintf("%s Requests: %d; Retries: %d; Sent %d bytes; Received %d bytes", color.New(color.FgWhite, color.Bold).Sprint("Finished!"), st.requests, st.retries, st.bytesTx, st.bytesRx))
-	printJSON(statsOutput{Type: "statistics", Requests: st.requests, Retries: st.retries, SentBytes: st.bytesTx, ReceivedBytes: st.bytesRx})
+	// Collect garbage first so the profile reflects live memory
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(fh); err != nil {
+		log.WithFields(log.Fields{"file": path, "err": err}).Error("Unable to write heap profile")
+	}
 
 }
 
 // Run kicks off scans from the command line
 func Run() {
 
-	// First things first
-	rand.Seed(time.Now().UTC().UnixNano())
-
 	// Parse and validate command-line arguments
 	p := arg.MustParse(&args)
-	args.Autocomplete = strings.ToLower(args.Autocomplete)
-	if args.Autocomplete != "auto" && args.Autocomplete != "method" && args.Autocomplete != "status" && args.Autocomplete != "distance" && args.Autocomplete != "none" {
-		p.Fail("autocomplete must be one of: auto, status, method, none")
+
+	normaliseOptions(&args)
+	if err := validateOptions(args); err != nil {
+		p.Fail(err.Error())
+	}
+	if args.Output == "none" {
+		p.Fail("output must be one of: human, json, tree, xml")
+	}
+	if args.Body != "" && args.BodyFile != "" {
+		p.Fail("--body and --body-file can't be combined")
+	}
+	if args.TUI && runTUI == nil {
+		p.Fail("this build doesn't include the interactive view (rebuild with -tags tui)")
 	}
-	args.Output = strings.ToLower(args.Output)
-	if args.Output != "human" && args.Output != "json" {
-		p.Fail("output must be one of: human, json")
+	if args.TUI && (args.Output != "human" || args.OutputDir != "") {
+		p.Fail("--tui can't be combined with -o or --output-dir")
+	}
+	if args.Serve != "" && (args.TUI || args.OutputDir != "") {
+		p.Fail("--serve can't be combined with --tui or --output-dir")
 	}
 
 	// Build the list of URLs to scan
@@ -1073,6 +4415,17 @@ func Run() {
 				log.WithFields(log.Fields{"path": path, "err": err}).Fatal("Error reading URL list file")
 			}
 
+		} else if _, _, err := net.ParseCIDR(url); err == nil {
+
+			// This is a CIDR range, add a URL for each host in it
+			hs, err := expandCidr(url)
+			if err != nil {
+				p.Fail(err.Error())
+			}
+			for _, h := range hs {
+				urls = append(urls, strings.Replace(args.CidrTemplate, "HOST", h, 1))
+			}
+
 		} else {
 
 			// This is a plain URL, add it to the list
@@ -1082,8 +4435,10 @@ func Run() {
 
 	}
 
-	// Say hello
-	printHuman(getBanner())
+	// Say hello (the interactive view has its own banner)
+	if args.Output == "human" && !args.TUI {
+		fmt.Println(getBanner())
+	}
 
 	// Warn if any filename characters are invalid (https://docs.microsoft.com/en-us/windows/win32/fileio/naming-a-file)
 	for _, c := range []string{"<", ">", ":", "\"", "/", "\\", "|", "?", "*"} {
@@ -1106,95 +4461,109 @@ func Run() {
 	}
 
 	// Build an HTTP client
+	tr, err := getTransport(args)
+	if err != nil {
+		p.Fail(err.Error())
+	}
 	hc := &http.Client{
 		Timeout:       time.Duration(args.Timeout) * time.Second,
-		Transport:     &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Renegotiation: tls.RenegotiateOnceAsClient}, Proxy: http.ProxyFromEnvironment},
+		Transport:     tr,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
 	}
 
 	// Initialise things
 	mk := markers{}
-	st := &httpStats{}
-	wc := wordlistConfig{}
-	statusCache = make(map[string]map[int]struct{})
-	distanceCache = make(map[string]map[int]distances)
-
-	// Compile the checksum detection regex
-	checksumRegex = regexp.MustCompile(".{1,2}[0-9A-F]{4}")
-
-	// Select the wordlist
-	var s *bufio.Scanner
-	if args.Wordlist != "" {
-		log.WithFields(log.Fields{"file": args.Wordlist}).Info("Using custom wordlist")
-		fh, err := os.Open(args.Wordlist)
+
+	// Merge in headers from a file
+	if args.HeadersFile != "" {
+		hs, err := readHeaders(args.HeadersFile)
 		if err != nil {
-			log.WithFields(log.Fields{"err": err}).Fatal("Unable to open wordlist")
+			p.Fail(err.Error())
 		}
-		s = bufio.NewScanner(fh)
-	} else {
-		log.Info("Using built-in wordlist")
-		fh, _ := defaultWordlist.Open("resources/wordlist.txt")
-		s = bufio.NewScanner(fh)
+		args.Headers = mergeHeaders(hs, args.Headers)
 	}
 
-	// Read the wordlist into memory
-	n := 0
-	for s.Scan() {
-
-		// Read the line
-		line := s.Text()
-
-		// Check the first line for the rainbow table magic value
-		if n == 0 && line == rainbowMagic {
-			wc.isRainbow = true
-			log.Info("Rainbow table provided, enabling auto dechecksumming")
-			continue
+	// Read the request body from a file
+	if args.BodyFile != "" {
+		b, err := os.ReadFile(args.BodyFile)
+		if err != nil {
+			p.Fail(fmt.Sprintf("unable to read body file: %s", err))
 		}
+		args.Body = string(b)
+	}
 
-		// Skip blank lines and comments
-		if l := len(line); l == 0 || line[0] == '#' {
-			continue
+	// Take headers and the target URL from a raw request (lowest precedence of all header sources)
+	if args.FromRequest != "" {
+		u, hs, err := readRequest(args.FromRequest)
+		if err != nil {
+			p.Fail(err.Error())
 		}
+		if len(urls) == 0 {
+			urls = append(urls, u)
+		}
+		args.Headers = mergeHeaders(hs, args.Headers)
+	}
+	if len(urls) == 0 && args.Serve == "" {
+		p.Fail("at least one URL (or --from-request) is required")
+	}
 
-		// Add the line to the wordlist
-		if wc.isRainbow {
-
-			// Check tab count
-			if strings.Count(line, "\t") != 4 {
-				log.WithFields(log.Fields{"line": line}).Fatal("Wordlist entry invalid (incorrect tab count)")
-				log.Fatal("")
-			}
+	// Create the output directory (files don't need colour codes, even if the terminal does)
+	if args.OutputDir != "" {
+		if err := os.MkdirAll(args.OutputDir, 0755); err != nil {
+			log.WithFields(log.Fields{"dir": args.OutputDir, "err": err}).Fatal("Unable to create output directory")
+		}
+		color.NoColor = true
+	}
 
-			// Split the line and add the word
-			c := strings.Split(line, "\t")
-			f, e, f83, e83 := c[3], c[4], c[1], c[2]
-			if len(e) > 0 {
-				e = "." + e
-			}
-			wc.wordlist = append(wc.wordlist, wordlistRecord{c[0], f, e, f83, e83})
+	// Start CPU profiling if requested (before setup, since loading big wordlists takes a while too)
+	if args.CpuProfile != "" {
+		fh, err := os.Create(args.CpuProfile)
+		if err != nil {
+			log.WithFields(log.Fields{"file": args.CpuProfile, "err": err}).Fatal("Unable to create CPU profile")
+		}
+		defer fh.Close()
+		if err := pprof.StartCPUProfile(fh); err != nil {
+			log.WithFields(log.Fields{"file": args.CpuProfile, "err": err}).Fatal("Unable to start CPU profile")
+		}
+	}
 
-		} else {
+	// Set up the scanner, reading in the wordlists, extensions and saved baselines
+	s, err := NewScanner(args, hc)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Fatal("Unable to set up scanner")
+	}
 
-			// Split the line into file and extension and generate an 8.3 version
-			var r wordlistRecord
-			if p := strings.LastIndex(line, "."); p > 0 && line[0] != '.' {
-				f, e := line[:p], line[p:]
-				_, f83, e83 := shortutil.Gen8dot3(f, e)
-				r = wordlistRecord{"", f, e, f83, e83}
-			} else {
-				_, f83, _ := shortutil.Gen8dot3(line, "")
-				r = wordlistRecord{"", line, "", f83, ""}
-			}
-			wc.wordlist = append(wc.wordlist, r)
+	// Run the HTTP API instead if requested
+	if args.Serve != "" {
+		err := s.serve(args.Serve)
+		s.Close()
+		log.WithFields(log.Fields{"addr": args.Serve, "err": err}).Fatal("API server stopped")
+	}
 
-		}
+	// Let's go!
+	var failed int
+	if args.TUI {
+		failed = runTUI(s, urls, mk)
+	} else {
+		failed = s.scanAll(context.Background(), urls, mk)
+	}
 
-		// Next
-		n += 1
+	// Save autocomplete baselines for next time and close the request log
+	if err := s.Close(); err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Unable to shut down cleanly")
+	}
 
+	// Stop CPU profiling and write out the heap profile if requested
+	if args.CpuProfile != "" {
+		pprof.StopCPUProfile()
+	}
+	if args.MemProfile != "" {
+		writeHeapProfile(args.MemProfile)
 	}
 
-	// Let's go!
-	Scan(urls, hc, st, wc, mk)
+	// Fail if none of the URLs could be scanned (the errors have already been logged)
+	if failed == len(urls) {
+		os.Exit(1)
+	}
 
 }
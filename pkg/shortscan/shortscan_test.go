@@ -0,0 +1,720 @@
+package shortscan
+
+import (
+	"io"
+	"os"
+	"time"
+	"math/rand"
+	"regexp"
+	"context"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"compress/flate"
+	"reflect"
+	"strings"
+	"net/http"
+	"sync/atomic"
+	"net/http/httptest"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"github.com/andybalholm/brotli"
+	log "github.com/sirupsen/logrus"
+)
+
+// Files on the emulated server, as long name and 8.3 alias
+var iisFiles = [][2]string{
+	{"default.aspx", "DEFAUL~1.ASP"},
+	{"backupfiles.zip", "BACKUP~1.ZIP"},
+	{"webconfig.config.bak", "WEBCON~1.BAK"},
+	{"longfilename1.txt", "LONGFI~1.TXT"},
+	{"longfilename2.txt", "LONGFI~2.TXT"},
+	{"uploadhandler.ashx", "UPLOAD~1.ASH"},
+}
+
+// newIIS starts a server which emulates IIS short name behaviour for the files in iisFiles at the web root, with
+// the number of requests made counted in n
+func newIIS(n *int64) *httptest.Server {
+	return newIISTree(n, map[string][][2]string{"/": iisFiles})
+}
+
+// newIISTree starts a server which emulates IIS short name behaviour for the given directories (each a lowercase path
+// ending in a slash, listing its files as long name and 8.3 alias): wildcard requests for a matching short name return
+// a 404 and non-matching ones a 400, and requests for a subdirectory without its trailing slash are redirected
+func newIISTree(n *int64, dirs map[string][][2]string) *httptest.Server {
+	return httptest.NewServer(iisHandler(n, dirs))
+}
+
+// iisHandler returns the handler behind newIISTree
+func iisHandler(n *int64, dirs map[string][][2]string) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		atomic.AddInt64(n, 1)
+		w.Header().Set("Server", "Microsoft-IIS/10.0")
+
+		// Find the deepest directory the request is in (case-insensitively, as IIS does)
+		dir := ""
+		for d := range dirs {
+			if strings.HasPrefix(strings.ToLower(r.URL.Path), d) && len(d) > len(dir) {
+				dir = d
+			}
+		}
+		files := dirs[dir]
+		p := r.URL.Path[len(dir):]
+
+		// Wildcard requests
+		if strings.ContainsAny(p, "*?") {
+			if !strings.HasSuffix(p, "/") {
+				w.WriteHeader(404)
+				return
+			}
+			re := wildcard(strings.TrimSuffix(p, "/"))
+			for _, f := range files {
+				if re.MatchString(f[1]) {
+					w.WriteHeader(404)
+					return
+				}
+			}
+			w.WriteHeader(400)
+			return
+		}
+
+		// Plain requests
+		for _, f := range files {
+			if strings.EqualFold(p, f[0]) || strings.EqualFold(p, f[1]) {
+				if r.Method == "_" {
+					w.WriteHeader(405)
+					return
+				}
+				if _, ok := dirs[dir+f[0]+"/"]; ok {
+					w.Header().Set("Location", dir+f[0]+"/")
+					w.WriteHeader(301)
+					return
+				}
+				w.WriteHeader(200)
+				io.WriteString(w, "content of "+f[0])
+				return
+			}
+		}
+		w.WriteHeader(404)
+		io.WriteString(w, "<html><body>Not found</body></html>")
+
+	})
+
+}
+
+// wildcard turns an IIS wildcard pattern into a case-insensitive regex (* matches anything, ? one character or none)
+func wildcard(p string) *regexp.Regexp {
+
+	var b strings.Builder
+	for _, c := range p {
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".?")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return regexp.MustCompile("(?i)^" + b.String() + "$")
+
+}
+
+// newBenchScanner returns a scanner using the built-in wordlist, with logging silenced
+func newBenchScanner(b *testing.B) *Scanner {
+
+	log.SetOutput(io.Discard)
+	s, err := NewScanner(DefaultOptions(), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return s
+
+}
+
+func TestNewScannerValidation(t *testing.T) {
+
+	log.SetOutput(io.Discard)
+
+	// Options a scan can't run with should be refused up front (manual markers must be given all together, otherwise
+	// the scan would dereference a missing suffix)
+	for name, f := range map[string]func(*Options){
+		"method without suffix": func(o *Options) { o.Method = "OPTIONS" },
+		"unknown autocomplete":  func(o *Options) { o.Autocomplete = "magic" },
+		"no API jobs":           func(o *Options) { o.ServeJobs = 0 },
+		"negative candidates":   func(o *Options) { o.MaxCandidates = -1 },
+		"loose threshold":       func(o *Options) { o.NegThreshold = 0.5 },
+	} {
+		opts := DefaultOptions()
+		f(&opts)
+		if _, err := NewScanner(opts, nil); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+
+	// Options are normalised as they are on the command line
+	opts := DefaultOptions()
+	opts.Autocomplete = "NONE"
+	if _, err := NewScanner(opts, nil); err != nil {
+		t.Errorf("upper case autocomplete mode was refused: %s", err)
+	}
+
+}
+
+func TestGetDistances(t *testing.T) {
+
+	var n int64
+	srv := newIIS(&n)
+	defer srv.Close()
+
+	opts := DefaultOptions()
+	opts.Autocomplete = "none"
+	s, err := NewScanner(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sampling should see the actual error page rather than an empty or padded body
+	ac := &attackConfig{rng: rand.New(rand.NewSource(1)), distanceCache: make(map[string]*distanceSample)}
+	dists := s.getDistances(context.Background(), newLimiter(1, false), wordlistRecord{extension: ".aspx"}, baseRequest{url: srv.URL + "/"}, &httpStats{}, ac)
+	if b := dists[404].body; b != "<html><body>Not found</body></html>" {
+		t.Errorf("sampled body = %q, want the error page", b)
+	}
+
+}
+
+func TestConfirmShort(t *testing.T) {
+
+	// A server with soft 404s, which serves DEFAUL~1.ASP and (if tildes are aliased) any other ~N name
+	aliased := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.URL.Path, "/DEFAUL~1.ASP") || (aliased && strings.Contains(r.URL.Path, "~")) {
+			io.WriteString(w, "<%@ Page Language=\"C#\" %><html><body><h1>Welcome to the application</h1></body></html>")
+			return
+		}
+		io.WriteString(w, "<html><body>Not found</body></html>")
+	}))
+	defer srv.Close()
+
+	log.SetOutput(io.Discard)
+	opts := DefaultOptions()
+	opts.Autocomplete = "none"
+	s, err := NewScanner(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirm := func(file string) bool {
+		ac := &attackConfig{rng: rand.New(rand.NewSource(1)), distanceCache: make(map[string]*distanceSample)}
+		br := baseRequest{url: srv.URL + "/", file: file, tilde: "~1", ext: ".ASP"}
+		return s.confirmShort(context.Background(), newLimiter(1, false), &httpStats{}, ac, br)
+	}
+
+	// Short names should be judged against the soft 404 rather than by status alone
+	if !confirm("DEFAUL") {
+		t.Error("DEFAUL~1.ASP wasn't confirmed")
+	}
+	if confirm("MISSIN") {
+		t.Error("MISSIN~1.ASP was confirmed despite matching the soft 404")
+	}
+
+	// Nothing should be confirmed when a ~N name which can't exist resolves too
+	aliased = true
+	if confirm("DEFAUL") {
+		t.Error("DEFAUL~1.ASP was confirmed despite the control resolving")
+	}
+
+}
+
+func TestFetchCompressed(t *testing.T) {
+
+	page := "<html><body>Not found</body></html>"
+	encoders := map[string]func(io.Writer) io.WriteCloser{
+		"gzip":    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+		"br":      func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) },
+	}
+
+	// Setting Accept-Encoding stops Go from decompressing responses itself
+	opts := DefaultOptions()
+	opts.Headers = []string{"Accept-Encoding: gzip, deflate, br"}
+	opts.Autocomplete = "none"
+	s, err := NewScanner(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for enc, nw := range encoders {
+
+		// Serve a compressed error page
+		var b bytes.Buffer
+		zw := nw(&b)
+		io.WriteString(zw, page)
+		zw.Close()
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", enc)
+			w.WriteHeader(404)
+			w.Write(b.Bytes())
+		}))
+		_, body, err := s.fetch(context.Background(), &httpStats{}, "GET", srv.URL+"/")
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != page {
+			t.Errorf("%s body = %q, want %q", enc, body, page)
+		}
+
+	}
+
+	// Raw deflate data is accepted too
+	var b bytes.Buffer
+	fw, _ := flate.NewWriter(&b, flate.DefaultCompression)
+	io.WriteString(fw, page)
+	fw.Close()
+	if d, _ := io.ReadAll(decodeBody("deflate", bufio.NewReader(&b))); string(d) != page {
+		t.Errorf("raw deflate body = %q, want %q", d, page)
+	}
+
+}
+
+func TestFingerprintIIS(t *testing.T) {
+
+	tests := []struct {
+		server, aspnet string
+		badMethod      int
+		want           string
+	}{
+		{"Microsoft-IIS/10.0", "", 405, "10.0"},
+		{"", "", 405, "7.0 or later"},
+		{"", "", 501, "5.0 to 6.0"},
+		{"Microsoft-HTTPAPI/2.0", "", 0, "7.0 or later"},
+		{"", "1.1.4322", 0, "5.0 to 6.0"},
+		{"Microsoft-IIS/6.0", "", 405, "unknown (conflicting evidence)"},
+		{"nginx", "", 400, ""},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.server != "" {
+			h.Set("Server", tt.server)
+		}
+		if tt.aspnet != "" {
+			h.Set("X-Aspnet-Version", tt.aspnet)
+		}
+		if v, _ := fingerprintIIS(h, tt.badMethod); v != tt.want {
+			t.Errorf("fingerprintIIS(%q, %q, %d) = %q, want %q", tt.server, tt.aspnet, tt.badMethod, v, tt.want)
+		}
+	}
+
+}
+
+func TestEarlyTermination(t *testing.T) {
+
+	// Nothing found and no characters to find isn't suspicious
+	ac := &attackConfig{fileChars: map[string]string{"~1": ""}}
+	if rs := earlyTermination(ac, 100, 0, 0); len(rs) > 0 {
+		t.Errorf("empty host gave reasons %v", rs)
+	}
+
+	// Characters without names is, as are lots of retries or failures (each counted once)
+	ac.fileChars["~1"] = "AB"
+	want := []string{"characters were found but no short names were enumerated", "20 retries were needed over 100 requests"}
+	if rs := earlyTermination(ac, 95, 20, 5); !reflect.DeepEqual(rs, want) {
+		t.Errorf("got reasons %q, want %q", rs, want)
+	}
+	want = []string{"characters were found but no short names were enumerated", "20 of 100 requests failed"}
+	if rs := earlyTermination(ac, 80, 0, 20); !reflect.DeepEqual(rs, want) {
+		t.Errorf("got reasons %q, want %q", rs, want)
+	}
+
+}
+
+func TestByHits(t *testing.T) {
+
+	ac := &attackConfig{}
+	if got := ac.byHits(hitKey(false, "~1"), "ABCD"); got != "ABCD" {
+		t.Errorf("byHits with no hits = %q, want %q", got, "ABCD")
+	}
+	for _, c := range "DDCB" {
+		ac.addHit(hitKey(false, "~1"), c)
+	}
+	if got := ac.byHits(hitKey(false, "~1"), "ABCD"); got != "DBCA" {
+		t.Errorf("byHits = %q, want %q", got, "DBCA")
+	}
+	if got := ac.byHits(hitKey(true, "~1"), "ABCD"); got != "ABCD" {
+		t.Errorf("byHits for extensions = %q, want %q", got, "ABCD")
+	}
+
+}
+
+func TestSuggestions(t *testing.T) {
+
+	s, err := NewScanner(DefaultOptions(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb := &resultBuffer{
+		statuses: []statusOutput{{Vulnerable: true}},
+		results: []resultOutput{
+			{Type: "file", ShortName: "WEBCON~1.BAK", File: "WEBCON", Tilde: "~1", Ext: ".BAK", FuzzPattern: "WEBCON?.BAK?"},
+			{Type: "directory", ShortName: "ADMINI~1", File: "ADMINI", Tilde: "~1", FuzzPattern: "ADMINI?"},
+			{Type: "file", ShortName: "DEFAUL~1.ASP", File: "DEFAUL", Tilde: "~1", Ext: ".ASP", FullMatch: true},
+			{Type: "file", ShortName: "GLOBAL~1.ASA", File: "GLOBAL", Tilde: "~1", Ext: ".ASA", FuzzPattern: "GLOBAL?.ASA?"},
+		},
+		detections: []detection{{method: "OPTIONS", suffix: "/", autocomplete: "method", wc: wildcardStyles["star"], mk: markers{404, 400}}},
+	}
+	got := strings.Join(s.suggestions(rb), "\n")
+	for _, want := range []string{"3 names couldn't be resolved", "--recurse-short", "--expand-ext --expand-ext-list asax", "--method OPTIONS --suffix '/' --status-pos 404 --status-neg 400", "-a method"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("suggestions missing %q:\n%s", want, got)
+		}
+	}
+
+	// Extensions already tried with --expand-ext aren't suggested again
+	s.opts.ExpandExt, s.opts.ExpandExtList = true, "bak, .asax"
+	if el := s.longerExtensions(rb.results[3:]); len(el) > 0 {
+		t.Errorf("suggested extensions %v which were already tried", el)
+	}
+
+	// Nothing vulnerable only suggests probing harder
+	got = strings.Join(s.suggestions(&resultBuffer{statuses: []statusOutput{{}}}), "\n")
+	if !strings.Contains(got, "-p 1") || strings.Contains(got, "--method") {
+		t.Errorf("suggestions for a non-vulnerable scan = %q", got)
+	}
+
+}
+
+func TestReadShortNames(t *testing.T) {
+
+	f := filepath.Join(t.TempDir(), "names.txt")
+	os.WriteFile(f, []byte("# from another tool\nDEFAUL~1.ASP\nadmini~1/\n\nAB~12.C\n"), 0600)
+	brs, err := readShortNames(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []baseRequest{{file: "DEFAUL", tilde: "~1", ext: ".ASP"}, {file: "ADMINI", tilde: "~1"}, {file: "AB", tilde: "~12", ext: ".C"}}
+	if !reflect.DeepEqual(brs, want) {
+		t.Errorf("readShortNames = %+v, want %+v", brs, want)
+	}
+
+	// Anything that isn't a short name is an error
+	os.WriteFile(f, []byte("DEFAULT.ASPX\n"), 0600)
+	if _, err := readShortNames(f); err == nil {
+		t.Error("readShortNames accepted a long name")
+	}
+
+}
+
+func TestWordlistCandidates(t *testing.T) {
+
+	f := filepath.Join(t.TempDir(), "words.txt")
+	os.WriteFile(f, []byte("default.aspx\t5\ndefaultpage.aspx\nlongextension.html\nreadme.txt\n"), 0600)
+	w, err := LoadWordlist(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs, _ := w.Candidates("defaul~1.asp"); !reflect.DeepEqual(cs, []string{"default.aspx", "defaultpage.aspx"}) {
+		t.Errorf("Candidates(DEFAUL~1.ASP) = %v", cs)
+	}
+	if cs, _ := w.Candidates("LONGEX~1.TXT"); len(cs) != 0 {
+		t.Errorf("Candidates(LONGEX~1.TXT) = %v, want none", cs)
+	}
+	if _, err := w.Candidates("default.aspx"); err == nil {
+		t.Error("Candidates accepted a long name")
+	}
+
+}
+
+func TestAutocompletePriority(t *testing.T) {
+
+	wc := &wordlistConfig{}
+	wl := "defaultpage.aspx\ndefault.aspx\t50\ndefaultx.aspx\t90\n"
+	if err := loadWordlist(wc, bufio.NewScanner(strings.NewReader(wl)), "test", true); err != nil {
+		t.Fatal(err)
+	}
+	indexWordlist(wc)
+
+	// Higher priority names come first, with unweighted names last
+	f := autocomplete(&attackConfig{wordlist: wc}, baseRequest{file: "DEFAUL", tilde: "~1", ext: ".ASP"})
+	var got []string
+	for _, r := range f {
+		got = append(got, r.filename)
+	}
+	if strings.Join(got, ",") != "defaultx,default,defaultpage" {
+		t.Errorf("candidate order = %v, want [defaultx default defaultpage]", got)
+	}
+
+	// Candidates with the same priority are ordered alphabetically
+	wc = &wordlistConfig{}
+	if err := loadWordlist(wc, bufio.NewScanner(strings.NewReader("defaultz.aspx\ndefaulta.aspx\ndefaultm.aspx\n")), "test", true); err != nil {
+		t.Fatal(err)
+	}
+	indexWordlist(wc)
+	for i := 0; i < 10; i++ {
+		f = autocomplete(&attackConfig{wordlist: wc}, baseRequest{file: "DEFAUL", tilde: "~1", ext: ".ASP"})
+		if f[0].filename != "defaulta" || f[1].filename != "defaultm" || f[2].filename != "defaultz" {
+			t.Fatalf("candidates not in alphabetical order: %v", f)
+		}
+	}
+
+}
+
+func TestScanSubpath(t *testing.T) {
+
+	var n int64
+	srv := newIISTree(&n, map[string][][2]string{
+		"/app/":           {{"default.aspx", "DEFAUL~1.ASP"}, {"subfolder", "SUBFOL~1"}},
+		"/app/subfolder/": {{"web.config", "WEB~1.CON"}},
+	})
+	defer srv.Close()
+
+	log.SetOutput(io.Discard)
+	s, err := NewScanner(DefaultOptions(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Scanning an application below the web root should find its files and recurse into its subdirectories
+	rs, err := s.Scan(context.Background(), srv.URL+"/app/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]string)
+	for _, r := range rs {
+		if r.Type == "file" || r.Type == "directory" {
+			found[r.BaseUrl+r.ShortName] = r.Fullname
+		}
+	}
+	for u, fn := range map[string]string{
+		srv.URL + "/app/DEFAUL~1.ASP":        "DEFAULT.ASPX",
+		srv.URL + "/app/SUBFOL~1":            "SUBFOLDER",
+		srv.URL + "/app/SUBFOLDER/WEB~1.CON": "WEB.CONFIG",
+	} {
+		if got, ok := found[u]; !ok || !strings.EqualFold(got, fn) {
+			t.Errorf("%s resolved to %q (found: %v), want %s", u, got, ok, fn)
+		}
+	}
+
+}
+
+func TestScanConcurrency(t *testing.T) {
+
+	// Track how many requests are in flight at once (each takes a moment so that overlapping requests are seen)
+	var n, active, peak int64
+	h := iisHandler(&n, map[string][][2]string{"/": iisFiles})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a := atomic.AddInt64(&active, 1)
+		defer atomic.AddInt64(&active, -1)
+		for p := atomic.LoadInt64(&peak); a > p && !atomic.CompareAndSwapInt64(&peak, p, a); p = atomic.LoadInt64(&peak) {
+		}
+		time.Sleep(2 * time.Millisecond)
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	// Status autocomplete samples baselines for each extension, which mustn't add to the scan's concurrency
+	log.SetOutput(io.Discard)
+	opts := DefaultOptions()
+	opts.Concurrency, opts.Autocomplete = 4, "status"
+	s, err := NewScanner(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Scan(context.Background(), srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	if peak > int64(opts.Concurrency) {
+		t.Errorf("%d requests were in flight at once, want at most %d", peak, opts.Concurrency)
+	}
+
+}
+
+func TestServe(t *testing.T) {
+
+	var n int64
+	iis := newIIS(&n)
+	defer iis.Close()
+
+	log.SetOutput(io.Discard)
+	s, err := NewScanner(DefaultOptions(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := newJobServer(s)
+	api := httptest.NewServer(js)
+	defer api.Close()
+
+	// Start a scan
+	res, err := http.Post(api.URL+"/scans", "application/json", strings.NewReader(`{"url":"`+iis.URL+`/"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var j struct{ ID, State string }
+	json.NewDecoder(res.Body).Decode(&j)
+	res.Body.Close()
+	if res.StatusCode != 202 || j.ID == "" {
+		t.Fatalf("starting a scan returned %d (%+v)", res.StatusCode, j)
+	}
+
+	// Follow the results until the scan finishes
+	res, err = http.Get(api.URL + "/scans/" + j.ID + "/results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := make(map[string]int)
+	found := false
+	dec := json.NewDecoder(res.Body)
+	for {
+		var r Result
+		if err := dec.Decode(&r); err != nil {
+			break
+		}
+		types[r.Type]++
+		found = found || r.Fullname == "DEFAULT.ASPX"
+	}
+	res.Body.Close()
+	if types["status"] != 1 || types["statistics"] != 1 || !found {
+		t.Errorf("unexpected records: %v (DEFAULT.ASPX found: %v)", types, found)
+	}
+
+	// The job should now be finished
+	res, err = http.Get(api.URL + "/scans/" + j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(res.Body).Decode(&j)
+	res.Body.Close()
+	if j.State != "finished" {
+		t.Errorf("scan state = %q, want finished", j.State)
+	}
+
+	// Deleting a finished job should forget it
+	req, _ := http.NewRequest(http.MethodDelete, api.URL+"/scans/"+j.ID, nil)
+	if res, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res, err = http.Get(api.URL + "/scans/" + j.ID); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 404 {
+		t.Errorf("deleted scan returned %d, want 404", res.StatusCode)
+	}
+
+	// Jobs which finished longer ago than the TTL should be pruned, and running ones kept
+	old := time.Now().Add(-2 * time.Hour)
+	js.jobs["old"] = &scanJob{ID: "old", Finished: &old}
+	js.jobs["running"] = &scanJob{ID: "running"}
+	js.prune()
+	if _, ok := js.jobs["old"]; ok {
+		t.Error("expired job wasn't pruned")
+	}
+	if _, ok := js.jobs["running"]; !ok {
+		t.Error("running job was pruned")
+	}
+
+}
+
+func TestServeToken(t *testing.T) {
+
+	log.SetOutput(io.Discard)
+	opts := DefaultOptions()
+	opts.ServeToken = "sekrit"
+	s, err := NewScanner(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := httptest.NewServer(newJobServer(s))
+	defer api.Close()
+
+	// Requests without the right token should be refused before reaching the API
+	for auth, want := range map[string]int{"": 401, "Bearer wrong": 401, "Bearer sekrit": 404} {
+		req, _ := http.NewRequest(http.MethodGet, api.URL+"/scans/nope", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Errorf("Authorization %q returned %d, want %d", auth, res.StatusCode, want)
+		}
+	}
+
+}
+
+func BenchmarkScan(b *testing.B) {
+
+	var n int64
+	srv := newIIS(&n)
+	defer srv.Close()
+	s := newBenchScanner(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		rs, err := s.Scan(context.Background(), srv.URL+"/")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(rs) == 0 {
+			b.Fatal("no results found")
+		}
+	}
+	b.ReportMetric(float64(n)/float64(b.N), "requests/op")
+	b.ReportMetric(float64(n)/time.Since(start).Seconds(), "requests/s")
+
+}
+
+func BenchmarkAutocomplete(b *testing.B) {
+
+	s := newBenchScanner(b)
+	ac := &attackConfig{wordlist: s.wordlist}
+	br := baseRequest{file: "DEFAUL", tilde: "~1", ext: ".ASP"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		autocomplete(ac, br)
+	}
+
+}
+
+// BenchmarkAutodechecksum compares dechecksumming using the checksum index with walking the whole wordlist (as
+// was done before the index was added) to match the prefix and checksum of a short name
+func BenchmarkAutodechecksum(b *testing.B) {
+
+	s := newBenchScanner(b)
+	ac := &attackConfig{wordlist: s.wordlist}
+	br := baseRequest{file: "DEBF66", tilde: "~1"}
+	prefix, checksum := br.file[:2], br.file[2:]
+
+	b.Run("indexed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			autodechecksum(ac, br)
+		}
+	})
+
+	b.Run("scan", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			fs := make(map[string]wordlistRecord)
+			for _, r := range ac.wordlist.wordlist {
+				for j := 0; j+4 <= len(r.checksums); j += 4 {
+					if r.checksums[j:j+4] == checksum && strings.HasPrefix(strings.ToUpper(r.filename), prefix) && strings.HasPrefix(strings.ToUpper(r.extension), br.ext) {
+						fs[r.filename+r.extension] = r
+					}
+				}
+			}
+		}
+	})
+
+}
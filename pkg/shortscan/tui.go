@@ -0,0 +1,251 @@
+//go:build tui
+
+// ------------------------------------------------------
+// Shortscan interactive results view
+// Built only with: go build -tags tui
+// ------------------------------------------------------
+
+package shortscan
+
+import (
+	"io"
+	"fmt"
+	"time"
+	"context"
+	"strings"
+	"github.com/fatih/color"
+	tea "github.com/charmbracelet/bubbletea"
+	log "github.com/sirupsen/logrus"
+)
+
+type tuiModel struct {
+	results   []Result
+	hosts     int
+	vuln      int
+	filter    string
+	filtering bool
+	offset    int
+	width     int
+	height    int
+	started   time.Time
+	elapsed   time.Duration
+	done      bool
+}
+
+type tuiResultMsg Result
+type tuiStatusMsg Status
+type tuiDoneMsg struct{}
+type tuiTickMsg time.Time
+
+// Register the interactive view with Run
+func init() {
+	runTUI = scanTUI
+}
+
+// scanTUI runs the scan in the background, feeding results into an interactive view until the user quits (which
+// cancels the scan if it's still going), returning the number of URLs that couldn't be scanned
+func scanTUI(s *Scanner, urls []string, mk markers) int {
+
+	// Keep line output and logging off the screen
+	s.out = io.Discard
+	lo := log.StandardLogger().Out
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(lo)
+
+	// Stream results and statuses into the view
+	p := tea.NewProgram(&tuiModel{started: time.Now()}, tea.WithAltScreen())
+	s.OnResult(func(r Result) { p.Send(tuiResultMsg(r)) })
+	s.OnStatus(func(st Status) { p.Send(tuiStatusMsg(st)) })
+
+	// Start the scan
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var failed int
+	done := make(chan struct{})
+	go func() {
+		failed = s.scanAll(ctx, urls, mk)
+		p.Send(tuiDoneMsg{})
+		close(done)
+	}()
+
+	// Run the view, then stop the scan and wait for it to wind down
+	if _, err := p.Run(); err != nil {
+		log.SetOutput(lo)
+		log.WithFields(log.Fields{"err": err}).Error("Unable to run the interactive view")
+	}
+	cancel()
+	<-done
+
+	return failed
+
+}
+
+// tuiTick updates the elapsed time once a second
+func tuiTick() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg { return tuiTickMsg(t) })
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return tuiTick()
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+
+	switch msg := msg.(type) {
+
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+
+	case tuiResultMsg:
+		m.results = append(m.results, Result(msg))
+
+	case tuiStatusMsg:
+		m.hosts++
+		if msg.Vulnerable {
+			m.vuln++
+		}
+
+	case tuiDoneMsg:
+		m.done = true
+		m.elapsed = time.Since(m.started)
+
+	case tuiTickMsg:
+		if !m.done {
+			m.elapsed = time.Since(m.started)
+			return m, tuiTick()
+		}
+
+	case tea.KeyMsg:
+
+		// Editing the filter
+		if m.filtering {
+			switch msg.Type {
+			case tea.KeyEnter:
+				m.filtering = false
+			case tea.KeyEsc:
+				m.filtering, m.filter = false, ""
+			case tea.KeyBackspace:
+				if len(m.filter) > 0 {
+					r := []rune(m.filter)
+					m.filter = string(r[:len(r)-1])
+				}
+			case tea.KeyRunes, tea.KeySpace:
+				m.filter += string(msg.Runes)
+			case tea.KeyCtrlC:
+				return m, tea.Quit
+			}
+			m.offset = 0
+			return m, nil
+		}
+
+		// Browsing
+		switch msg.String() {
+		case "q", "ctrl+c":
+			return m, tea.Quit
+		case "/":
+			m.filtering = true
+		case "esc":
+			m.filter, m.offset = "", 0
+		case "up", "k":
+			m.offset--
+		case "down", "j":
+			m.offset++
+		case "pgup":
+			m.offset -= m.pageSize()
+		case "pgdown", " ":
+			m.offset += m.pageSize()
+		case "home", "g":
+			m.offset = 0
+		case "end", "G":
+			m.offset = 1 << 30
+		}
+
+	}
+
+	return m, nil
+
+}
+
+// pageSize returns how many tree lines fit between the header and footer
+func (m *tuiModel) pageSize() int {
+	if m.height <= 4 {
+		return 20
+	}
+	return m.height - 4
+}
+
+// matches reports whether a result matches the filter (a case-insensitive substring of its names or extension)
+func (m *tuiModel) matches(r Result) bool {
+	f := strings.ToLower(m.filter)
+	return f == "" || strings.Contains(strings.ToLower(r.ShortName+" "+r.Fullname+" "+r.Ext), f)
+}
+
+func (m *tuiModel) View() string {
+
+	// Tally the results
+	var files, dirs, partials int
+	var shown []Result
+	for _, r := range m.results {
+		switch {
+		case r.Type == "directory":
+			dirs++
+		case r.FullMatch:
+			files++
+		default:
+			partials++
+		}
+		if m.matches(r) {
+			shown = append(shown, r)
+		}
+	}
+
+	// Render the tree of matching results and keep the scroll position in range
+	var b strings.Builder
+	writeTree(&b, buildTree(shown))
+	lines := strings.Split(strings.TrimRight(b.String(), "\n"), "\n")
+	if len(shown) == 0 {
+		lines = []string{color.HiBlackString("Nothing found yet")}
+		if m.filter != "" {
+			lines = []string{color.HiBlackString("No results match the filter")}
+		}
+	}
+	ps := m.pageSize()
+	if m.offset > len(lines)-ps {
+		m.offset = len(lines) - ps
+	}
+	if m.offset < 0 {
+		m.offset = 0
+	}
+	end := m.offset + ps
+	if end > len(lines) {
+		end = len(lines)
+	}
+
+	// Header with the scan stats
+	state := color.HiYellowString("scanning")
+	if m.done {
+		state = color.HiGreenString("finished")
+	}
+	var v strings.Builder
+	fmt.Fprintf(&v, "%s · %s · URLs: %d (%d vulnerable) · Files: %d · Directories: %d · Partial: %d · %s\n\n",
+		color.New(color.FgBlue, color.Bold).Sprint("🌀 Shortscan v"+version), state, m.hosts, m.vuln, files, dirs, partials, m.elapsed.Round(time.Second))
+
+	// Visible part of the tree
+	v.WriteString(strings.Join(lines[m.offset:end], "\n"))
+	v.WriteString("\n")
+	for i := end - m.offset; i < ps; i++ {
+		v.WriteString("\n")
+	}
+
+	// Footer with the filter or key help
+	if m.filtering {
+		fmt.Fprintf(&v, "\nFilter: %s█", m.filter)
+	} else if m.filter != "" {
+		fmt.Fprintf(&v, "\n%s", color.HiBlackString("Filter: %s (%d of %d) · / edit · esc clear · ↑/↓ scroll · q quit", m.filter, len(shown), len(m.results)))
+	} else {
+		fmt.Fprintf(&v, "\n%s", color.HiBlackString("/ filter · ↑/↓ scroll · q quit"))
+	}
+
+	return v.String()
+
+}
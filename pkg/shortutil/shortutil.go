@@ -17,6 +17,8 @@ import (
 	"bufio"
 	"regexp"
 	"strings"
+	"strconv"
+	"unicode"
 	"net/url"
 	"github.com/fatih/color"
 	"github.com/alexflint/go-arg"
@@ -29,6 +31,7 @@ type wordlistRecord struct {
 	extension   string
 	filename83  string
 	extension83 string
+	priority    string
 }
 
 // Command-line arguments
@@ -38,13 +41,37 @@ var args struct {
 		KeepCase bool   `arg:"--keepcase" help:"keep the original case rather than upper-casing words" default:"false"`
 		Uniq     bool   `arg:"--uniq" help:"allow only unique words" default:"true"`
 		Variants bool   `arg:"--variants" help:"generate checksums for case variants of input words (e.g. ping.aspx, Ping.aspx, PING.ASPX)" default:"true"`
+		CasePerm bool   `arg:"--case-permutations" help:"also generate checksums for common mixed casings of the name and extension (e.g. Ping.ASPX, PING.aspx; roughly triples the checksums per word)" default:"false"`
+		CaseMax  int    `arg:"--case-permutations-max" help:"generate checksums for every casing of words with up to this many letters (2^letters checksums per word, so keep this small; 0 = off)" placeholder:"LETTERS" default:"0"`
 	} `arg:"subcommand:wordlist" help:"add hashes to a wordlist for use with, for example, shortscan"`
 	Checksum *struct {
 		Filename string `arg:"positional,required" help:"filename to checksum"`
 		Original bool   `arg:"-o" help:"use the original (Windows Server 2003 + Windows XP) algorithm" default:"false"`
+		Compare  bool   `arg:"--compare" help:"show the results of both algorithms side by side, along with the generated 8.3 name and checksummed aliases" default:"false"`
 	} `arg:"subcommand:checksum" help:"generate a one-off checksum for the given filename"`
+	Gen83 *struct {
+		Filename string `arg:"positional,required" help:"filename to generate a short name for"`
+		Required bool   `arg:"-r" help:"also show whether Windows would need to generate a short name" default:"false"`
+	} `arg:"subcommand:gen83" help:"generate the 8.3 short name for the given filename"`
+	Aliases *struct {
+		Filename string `arg:"positional,required" help:"file listing the filenames in a directory, one per line, in the order they were created"`
+		Original bool   `arg:"-o" help:"use the original (Windows Server 2003 + Windows XP) checksum algorithm" default:"false"`
+	} `arg:"subcommand:aliases" help:"predict the short name aliases (including ~N numbering and checksums) for a directory listing"`
+	Autocomplete *struct {
+		Filename string `arg:"positional,required" help:"file listing the short names, one per line (e.g. DEFAUL~1.ASP)"`
+		Wordlist string `arg:"-w" help:"wordlist or rainbow table to match against (default: shortscan's built-in wordlist)" placeholder:"FILE"`
+	} `arg:"subcommand:autocomplete" help:"list candidate full filenames for short names from a wordlist, without making any requests"`
 }
 
+// Completer lists the candidate full filenames for a short name, most likely first
+type Completer interface {
+	Candidates(shortName string) ([]string, error)
+}
+
+// LoadCompleter loads a wordlist for the autocomplete command (an empty path means the built-in wordlist). The
+// matching lives in shortscan, which imports this package, so the shortutil command sets this
+var LoadCompleter func(wordlist string) (Completer, error)
+
 // Regular expression to strip URL parameters
 var paramRegex = regexp.MustCompile("[?;#&\r\n]")
 
@@ -55,6 +82,9 @@ var shortReplacer = strings.NewReplacer(" ", "", ".", "", ":", "_", "+", "_", ",
 // Version
 const version = "0.4"
 
+// Largest number of letters that every casing will be generated for (2^16 checksums per word)
+const maxCasePermutations = 16
+
 // Checksum calculates the short filename checksum for the given filename
 // Based on: https://tomgalvin.uk/assets/8dot3-checksum.c
 // Docs: https://tomgalvin.uk/blog/gen/2015/06/09/filenames/
@@ -80,16 +110,22 @@ func Checksum(f string) string {
 // of the checksum algorithm contained in the leaked Windows 2003 Server source
 func ChecksumOriginal(f string) string {
 
+	// Seed the checksum with the first two characters (a missing character counts as zero, as with odd lengths)
 	var ck uint16
-	ck = (uint16(f[0])<<8 + uint16(f[1])) & 0xffff
-	for i := 2; i < len(f); i+=2 {
-		if ck & 1 == 1 {
+	if len(f) > 0 {
+		ck = uint16(f[0]) << 8
+	}
+	if len(f) > 1 {
+		ck += uint16(f[1])
+	}
+	for i := 2; i < len(f); i += 2 {
+		if ck&1 == 1 {
 			ck = 0x8000 + ck>>1 + uint16(f[i])<<8
 		} else {
 			ck = ck>>1 + uint16(f[i])<<8
 		}
-		if (i+1 < len(f)) {
-		    ck += uint16(f[i+1]) & 0xffff
+		if i+1 < len(f) {
+			ck += uint16(f[i+1]) & 0xffff
 		}
 	}
 
@@ -98,6 +134,86 @@ func ChecksumOriginal(f string) string {
 
 }
 
+// CompareChecksums returns a table comparing the two checksum algorithms for a filename: the generated 8.3 name,
+// whether Windows would need one, then each algorithm's checksum and the checksummed alias it would produce (as
+// used once four names share a stem), which helps explain why a rainbow table built with one fails against the other
+func CompareChecksums(f string) [][2]string {
+
+	r, f83, e83 := Gen8dot3(splitExt(f))
+	if e83 != "" {
+		e83 = "." + e83
+	}
+	stem := f83[:maths.Min(len(f83), 2)]
+	c, co := Checksum(f), ChecksumOriginal(f)
+
+	return [][2]string{
+		{"Filename", f},
+		{"Gen8dot3", f83 + "~1" + e83},
+		{"Short name required", strconv.FormatBool(r)},
+		{"Checksum", c},
+		{"Checksummed alias", stem + c + "~1" + e83},
+		{"ChecksumOriginal", co},
+		{"Checksummed alias", stem + co + "~1" + e83},
+	}
+
+}
+
+// Aliases returns the short name alias Windows would assign to each of the given filenames, assuming they were
+// created in order in the same directory. The first four names sharing an 8.3 stem get ~1 to ~4 and later ones
+// get a checksummed stem (the first two characters plus the checksum of the long name). Names which don't need
+// a short name get an empty alias
+func Aliases(names []string, original bool) []string {
+
+	// Choose the checksum algorithm
+	checksum := Checksum
+	if original {
+		checksum = ChecksumOriginal
+	}
+
+	// Assign an alias to each name in turn, skipping any already taken
+	used := make(map[string]struct{})
+	as := make([]string, len(names))
+	for i, n := range names {
+
+		// Names that don't need a short name still take up their own name
+		r, f83, e83 := Gen8dot3(splitExt(n))
+		if !r {
+			used[strings.ToUpper(n)] = struct{}{}
+			continue
+		}
+		if e83 != "" {
+			e83 = "." + e83
+		}
+
+		// Try ~1 to ~4 on the plain stem, then fall back to the checksummed stem
+		var a string
+		for t := 1; t <= 4 && a == ""; t++ {
+			if c := fmt.Sprintf("%s~%d%s", f83, t, e83); !taken(used, c) {
+				a = c
+			}
+		}
+		cs := f83[:maths.Min(len(f83), 2)] + checksum(n)
+		for t := 1; a == ""; t++ {
+			if c := fmt.Sprintf("%s~%d%s", cs, t, e83); !taken(used, c) {
+				a = c
+			}
+		}
+
+		used[a] = struct{}{}
+		as[i] = a
+
+	}
+
+	return as
+
+}
+
+// taken reports whether an alias has already been used
+func taken(used map[string]struct{}, a string) bool {
+	_, ok := used[a]
+	return ok
+}
+
 // Gen8dot3 returns the Windows short filename for a given filename (sans tilde)
 func Gen8dot3(file string, ext string) (bool, string, string) {
 
@@ -110,13 +226,71 @@ func Gen8dot3(file string, ext string) (bool, string, string) {
 	er := shortReplacer.Replace(eu)
 
 	// Determine whether a short filename was required
-	r := len(file) > 8 || len (ext) > 3 || fu != fr || eu != er
+	r := len(file) > 8 || len(ext) > 3 || fu != fr || eu != er
 
 	// Trim and return the names
 	return r, fr[:maths.Min(len(fr), 6)], er[:maths.Min(len(er), 3)]
 
 }
 
+// splitExt splits a filename into name and extension at the last dot (a leading dot isn't an extension)
+func splitExt(w string) (string, string) {
+	if p := strings.LastIndex(w, "."); p > 0 && w[0] != '.' {
+		return w[:p], w[p+1:]
+	}
+	return w, ""
+}
+
+// CaseVariants returns common mixed casings of a filename, pairing each casing of the name
+// (as-is, lower, upper, title) with each casing of the extension (as-is, lower, upper)
+func CaseVariants(file string, ext string) []string {
+
+	fl := strings.ToLower(file)
+	fs := []string{file, fl, strings.ToUpper(file), strings.Title(fl)}
+	if ext == "" {
+		return fs
+	}
+
+	var vs []string
+	for _, f := range fs {
+		for _, e := range []string{ext, strings.ToLower(ext), strings.ToUpper(ext)} {
+			vs = append(vs, f+"."+e)
+		}
+	}
+	return vs
+
+}
+
+// CasePermutations returns every casing of a word, or nil if it has more than max letters
+func CasePermutations(w string, max int) []string {
+
+	// Find the positions of letters that have a distinct upper and lower case
+	rs := []rune(strings.ToLower(w))
+	var ls []int
+	for i, r := range rs {
+		if unicode.ToUpper(r) != r {
+			ls = append(ls, i)
+		}
+	}
+	if len(ls) > max {
+		return nil
+	}
+
+	// Treat each permutation number as a bitmask of which letters to upper case
+	vs := make([]string, 0, 1<<len(ls))
+	for n := 0; n < 1<<len(ls); n++ {
+		p := append([]rune(nil), rs...)
+		for b, i := range ls {
+			if n&(1<<b) != 0 {
+				p[i] = unicode.ToUpper(p[i])
+			}
+		}
+		vs = append(vs, string(p))
+	}
+	return vs
+
+}
+
 // ChecksumWords turns a list of words into a word/checksum map
 func ChecksumWords(fh io.Reader, paramRegex *regexp.Regexp) []wordlistRecord {
 
@@ -125,8 +299,16 @@ func ChecksumWords(fh io.Reader, paramRegex *regexp.Regexp) []wordlistRecord {
 	s := bufio.NewScanner(fh)
 	for s.Scan() {
 
+		// Split off the priority if there is one (word, tab, priority)
+		w, pr := s.Text(), ""
+		if p := strings.LastIndex(w, "\t"); p >= 0 {
+			if _, err := strconv.Atoi(w[p+1:]); err == nil {
+				w, pr = w[:p], w[p+1:]
+			}
+		}
+
 		// Unescape any URL-encoded characters
-		w, _ := url.PathUnescape(s.Text())
+		w, _ = url.PathUnescape(w)
 		w, _ = url.PathUnescape(w)
 
 		// Remove any path elements, anything that looks like a parameter, trim whitespace and remove tabs
@@ -137,12 +319,7 @@ func ChecksumWords(fh io.Reader, paramRegex *regexp.Regexp) []wordlistRecord {
 		w = strings.ReplaceAll(w, "\t", "")
 
 		// Split the file and extension
-		var f, e string
-		if p := strings.LastIndex(w, "."); p > 0 && w[0] != '.' {
-			f, e = w[:p], w[p + 1:]
-		} else {
-			f, e = w, ""
-		}
+		f, e := splitExt(w)
 
 		// Generate an 8.3 filename for the word
 		r, f83, e83 := Gen8dot3(f, e)
@@ -160,13 +337,23 @@ func ChecksumWords(fh io.Reader, paramRegex *regexp.Regexp) []wordlistRecord {
 			vs[Checksum(strings.ToUpper(w))] = struct{}{}
 			vs[Checksum(strings.Title(w))] = struct{}{}
 		}
+		if args.Wordlist.CasePerm {
+			for _, v := range CaseVariants(f, e) {
+				vs[Checksum(v)] = struct{}{}
+			}
+		}
+		if args.Wordlist.CaseMax > 0 {
+			for _, v := range CasePermutations(w, args.Wordlist.CaseMax) {
+				vs[Checksum(v)] = struct{}{}
+			}
+		}
 		var c string
 		for v := range vs {
 			c += v
 		}
 
 		// Add the wordlist entry to the list
-		wc = append(wc, wordlistRecord{c, f, e, f83, e83})
+		wc = append(wc, wordlistRecord{c, f, e, f83, e83, pr})
 
 	}
 
@@ -180,8 +367,14 @@ func Run() {
 
 	// Parse command-line arguments
 	p := arg.MustParse(&args)
+	if args.Wordlist != nil && (args.Wordlist.CaseMax < 0 || args.Wordlist.CaseMax > maxCasePermutations) {
+		p.Fail(fmt.Sprintf("--case-permutations-max must be between 0 and %d", maxCasePermutations))
+	}
+	if args.Checksum != nil && args.Checksum.Compare && args.Checksum.Original {
+		p.Fail("--compare already shows both algorithms, so can't be combined with -o")
+	}
 	if p.Subcommand() == nil {
-		fmt.Println(color.New(color.FgBlue, color.Bold).Sprint("Shortutil v" + version), "·", color.New(color.FgWhite, color.Bold).Sprint("a short filename utility by bitquark"))
+		fmt.Println(color.New(color.FgBlue, color.Bold).Sprint("Shortutil v"+version), "·", color.New(color.FgWhite, color.Bold).Sprint("a short filename utility by bitquark"))
 		p.WriteHelp(os.Stderr)
 		os.Exit(1)
 	}
@@ -223,13 +416,23 @@ func Run() {
 				words[fe] = struct{}{}
 			}
 
-			// Output the entry
-			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", w.checksum, w.filename83, w.extension83, f, e)
+			// Output the entry, with the priority column only if one was given
+			if w.priority != "" {
+				fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n", w.checksum, w.filename83, w.extension83, f, e, w.priority)
+			} else {
+				fmt.Printf("%s\t%s\t%s\t%s\t%s\n", w.checksum, w.filename83, w.extension83, f, e)
+			}
 
 		}
 
 	// Generate a one-off checksum
 	case args.Checksum != nil:
+		if args.Checksum.Compare {
+			for _, r := range CompareChecksums(args.Checksum.Filename) {
+				fmt.Printf("%-20s %s\n", r[0], r[1])
+			}
+			break
+		}
 		var c string
 		if args.Checksum.Original {
 			c = ChecksumOriginal(args.Checksum.Filename)
@@ -237,6 +440,85 @@ func Run() {
 			c = Checksum(args.Checksum.Filename)
 		}
 		fmt.Println(c)
+
+	// Predict the aliases for a directory listing
+	case args.Aliases != nil:
+
+		// Read the listing
+		fh, err := os.Open(args.Aliases.Filename)
+		if err != nil {
+			log.Fatalf("Error: %s\n", err)
+		}
+		var ns []string
+		s := bufio.NewScanner(fh)
+		for s.Scan() {
+			if n := strings.TrimSpace(s.Text()); n != "" {
+				ns = append(ns, n)
+			}
+		}
+		if err := s.Err(); err != nil {
+			log.Fatalf("Error: %s\n", err)
+		}
+
+		// Output each alias alongside its long name
+		for i, a := range Aliases(ns, args.Aliases.Original) {
+			if a != "" {
+				fmt.Printf("%s\t%s\n", a, ns[i])
+			}
+		}
+
+	// List candidate full filenames for short names
+	case args.Autocomplete != nil:
+
+		// Load the wordlist
+		if LoadCompleter == nil {
+			log.Fatalf("Error: autocomplete isn't available in this build\n")
+		}
+		c, err := LoadCompleter(args.Autocomplete.Wordlist)
+		if err != nil {
+			log.Fatalf("Error: %s\n", err)
+		}
+
+		// Read the short names
+		fh, err := os.Open(args.Autocomplete.Filename)
+		if err != nil {
+			log.Fatalf("Error: %s\n", err)
+		}
+		s := bufio.NewScanner(fh)
+		for s.Scan() {
+
+			// Skip blank lines and comments
+			n := strings.TrimSpace(s.Text())
+			if n == "" || strings.HasPrefix(n, "#") {
+				continue
+			}
+
+			// Output each candidate alongside its short name
+			cs, err := c.Candidates(n)
+			if err != nil {
+				log.Fatalf("Error: %s\n", err)
+			}
+			for _, f := range cs {
+				fmt.Printf("%s\t%s\n", strings.ToUpper(n), f)
+			}
+
+		}
+		if err := s.Err(); err != nil {
+			log.Fatalf("Error: %s\n", err)
+		}
+
+	// Generate a one-off 8.3 short name (the first in a directory, hence ~1)
+	case args.Gen83 != nil:
+		r, f83, e83 := Gen8dot3(splitExt(args.Gen83.Filename))
+		sn := f83 + "~1"
+		if e83 != "" {
+			sn += "." + e83
+		}
+		if args.Gen83.Required {
+			fmt.Printf("%s\t%t\n", sn, r)
+		} else {
+			fmt.Println(sn)
+		}
 	}
 
 }
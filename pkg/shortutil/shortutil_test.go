@@ -0,0 +1,174 @@
+package shortutil
+
+import (
+	"regexp"
+	"testing"
+)
+
+// Checksums are always four upper case hex digits
+var checksumFormat = regexp.MustCompile("^[0-9A-F]{4}$")
+
+// Known checksum vectors (cross-checked against an independent implementation of both algorithms)
+var checksumTests = []struct {
+	name     string
+	checksum string
+	original string
+}{
+	{"ab", "69D1", "2616"},
+	{"abc", "71C0", "1B39"},
+	{"abcde", "EAD6", "A0F2"},
+	{"default.aspx", "4D64", "37E5"},
+	{"index.html", "2EF5", "5D2D"},
+	{"web.config", "F71C", "13DF"},
+	{"longfilename.txt", "9EEC", "F53D"},
+	{"Program_Files", "B49E", "B546"},
+	{"a+b,c.txt", "6727", "F596"},
+	{"file[1];x=y.txt", "5EF1", "AB03"},
+	{"é.txt", "B490", "8908"},
+}
+
+func TestChecksum(t *testing.T) {
+
+	for _, tt := range checksumTests {
+		if c := Checksum(tt.name); c != tt.checksum {
+			t.Errorf("Checksum(%q) = %s, want %s", tt.name, c, tt.checksum)
+		}
+	}
+
+	// Single character names
+	for name, want := range map[string]string{"a": "58EE", "Z": "7FB1", "~": "631F"} {
+		if c := Checksum(name); c != want {
+			t.Errorf("Checksum(%q) = %s, want %s", name, c, want)
+		}
+	}
+
+}
+
+func TestChecksumOriginal(t *testing.T) {
+
+	for _, tt := range checksumTests {
+		if c := ChecksumOriginal(tt.name); c != tt.original {
+			t.Errorf("ChecksumOriginal(%q) = %s, want %s", tt.name, c, tt.original)
+		}
+	}
+
+}
+
+func TestChecksumShort(t *testing.T) {
+
+	// Empty and single character names mustn't panic
+	for name, want := range map[string]string{"": "0000", "a": "0016", "Z": "00A5"} {
+		if c := ChecksumOriginal(name); c != want {
+			t.Errorf("ChecksumOriginal(%q) = %s, want %s", name, c, want)
+		}
+	}
+	if c := Checksum(""); c != "0000" {
+		t.Errorf("Checksum(\"\") = %s, want 0000", c)
+	}
+
+}
+
+func TestChecksumCase(t *testing.T) {
+
+	// Checksums are case sensitive, which is why wordlists include case variants
+	if Checksum("Default.aspx") == Checksum("default.aspx") {
+		t.Error("Checksum should differ between case variants")
+	}
+
+}
+
+func TestChecksumFormat(t *testing.T) {
+
+	for _, name := range []string{"a", "ab", "abc", "a.b", "0000000000000000.txt", "[];:+,=.xyz"} {
+		if c := Checksum(name); !checksumFormat.MatchString(c) {
+			t.Errorf("Checksum(%q) = %q, not four hex digits", name, c)
+		}
+		if c := ChecksumOriginal(name); !checksumFormat.MatchString(c) {
+			t.Errorf("ChecksumOriginal(%q) = %q, not four hex digits", name, c)
+		}
+	}
+
+}
+
+func TestCasePermutations(t *testing.T) {
+
+	// Every casing of the letters, leaving other characters alone
+	vs := CasePermutations("a-b1", 2)
+	want := map[string]bool{"a-b1": true, "A-b1": true, "a-B1": true, "A-B1": true}
+	if len(vs) != len(want) {
+		t.Fatalf("CasePermutations returned %d variants, want %d", len(vs), len(want))
+	}
+	for _, v := range vs {
+		if !want[v] {
+			t.Errorf("unexpected variant %q", v)
+		}
+	}
+
+	// Words over the letter cap are skipped
+	if vs := CasePermutations("abc", 2); vs != nil {
+		t.Errorf("CasePermutations over the cap = %v, want nil", vs)
+	}
+
+}
+
+func TestCaseVariants(t *testing.T) {
+
+	if n := len(CaseVariants("MyFile", "aspx")); n != 12 {
+		t.Errorf("CaseVariants returned %d variants, want 12", n)
+	}
+	if n := len(CaseVariants("MyFile", "")); n != 4 {
+		t.Errorf("CaseVariants without an extension returned %d variants, want 4", n)
+	}
+
+}
+
+func TestAliases(t *testing.T) {
+
+	names := []string{
+		"longfilename1.txt", "longfilename2.txt", "longfilename3.txt", "longfilename4.txt",
+		"longfilename5.txt", "longfilename6.txt", "readme.txt", "longfilename.html",
+	}
+	want := []string{
+		"LONGFI~1.TXT", "LONGFI~2.TXT", "LONGFI~3.TXT", "LONGFI~4.TXT",
+		"LO58E4~1.TXT", "LO735D~1.TXT", "", "LONGFI~1.HTM",
+	}
+	for i, a := range Aliases(names, false) {
+		if a != want[i] {
+			t.Errorf("alias for %q = %q, want %q", names[i], a, want[i])
+		}
+	}
+
+	// A long name that looks like an alias takes that alias
+	if as := Aliases([]string{"longfi~1.txt", "longfilename.txt"}, false); as[1] != "LONGFI~2.TXT" {
+		t.Errorf("alias after a taken name = %q, want LONGFI~2.TXT", as[1])
+	}
+
+}
+
+func TestCompareChecksums(t *testing.T) {
+
+	want := [][2]string{
+		{"Filename", "Web.Config.Backup"},
+		{"Gen8dot3", "WEBCON~1.BAC"},
+		{"Short name required", "true"},
+		{"Checksum", Checksum("Web.Config.Backup")},
+		{"Checksummed alias", "WE" + Checksum("Web.Config.Backup") + "~1.BAC"},
+		{"ChecksumOriginal", ChecksumOriginal("Web.Config.Backup")},
+		{"Checksummed alias", "WE" + ChecksumOriginal("Web.Config.Backup") + "~1.BAC"},
+	}
+	got := CompareChecksums("Web.Config.Backup")
+	if len(got) != len(want) {
+		t.Fatalf("CompareChecksums returned %d rows, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("CompareChecksums row %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// Names without an extension get an alias without one
+	if got := CompareChecksums("averylongname"); got[1][1] != "AVERYL~1" {
+		t.Errorf("CompareChecksums 8.3 name = %q, want AVERYL~1", got[1][1])
+	}
+
+}